@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/spf13/cobra"
+)
+
+// auditCmd reports drift between the local settings and the target repositories
+var auditCmd = &cobra.Command{
+	Use:   "audit [owner/repo]...",
+	Short: "Report drift between local settings and Gitea repositories",
+	Long: `Pulls the live settings of every target repository and compares them
+with the YAML files in the output directory. Differences are reported per
+repository, setting type and field. The command never writes to Gitea, so
+it can run with a read-only token.
+
+Exits with code 2 if any drift was found and 1 on errors.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := LoadConfig(cfgFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		client, err := gitea.NewClient(cfg.GiteaURL, gitea.SetToken(cfg.GiteaToken))
+		if err != nil {
+			return fmt.Errorf("failed to create Gitea client: %w", err)
+		}
+
+		groupNames, err := cmd.Flags().GetStringSlice("group")
+		if err != nil {
+			return fmt.Errorf("could not parse --group flag: %w", err)
+		}
+
+		groups, err := resolveTargetGroups(cmd, client, cfg, args, groupNames)
+		if err != nil {
+			return err
+		}
+		if countRepos(groups) == 0 {
+			return errors.New("no repositories to process after merges/exclusions")
+		}
+
+		concurrency, err := concurrencyFromFlags(cmd, cfg)
+		if err != nil {
+			return err
+		}
+
+		// dry run handlers as a safeguard; audit only calls Load, Pull and Diff
+		results, handlerNames := forEachGroupRepo(groups, concurrency, false,
+			func(groupCfg *Config) []ConfigHandler {
+				return pushHandlers(groupCfg, true)
+			},
+			func(g targetGroup, handlers []ConfigHandler, fullName string) ([]handlerResult, error) {
+				return auditRepo(client, g.Config, handlers, fullName)
+			},
+		)
+
+		if len(handlerNames) == 0 {
+			return nil
+		}
+
+		out := cmd.OutOrStdout()
+		var drifted, failed int
+		for _, r := range results {
+			if printDrift(out, r) {
+				drifted++
+			}
+			if r.Err != nil {
+				failed++
+			}
+		}
+
+		fmt.Fprintf(out, "\nAudit: %d of %d repositories drifted, %d failed.\n", drifted, len(results), failed)
+
+		if failed > 0 {
+			return fmt.Errorf("failed to audit %d of %d repositories", failed, len(results))
+		}
+		if drifted > 0 {
+			return &exitCodeError{
+				code: ExitCodeDrift,
+				err:  fmt.Errorf("drift detected in %d of %d repositories", drifted, len(results)),
+			}
+		}
+		return nil
+	},
+}
+
+// auditRepo compares the live state of every handler with the local data. It
+// only ever reads from Gitea.
+func auditRepo(client *gitea.Client, cfg *Config, handlers []ConfigHandler, fullName string) ([]handlerResult, error) {
+	owner, repo, err := parseRepoString(fullName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repo argument %q: %w", fullName, err)
+	}
+
+	outputDir := resolveOutputDir(cfg)
+	vars := newRepoVars(client, cfg, owner, repo)
+
+	results := make([]handlerResult, 0, len(handlers))
+	var errs []error
+	for _, handler := range handlers {
+		if !handler.Enabled() {
+			continue
+		}
+
+		start := time.Now()
+		drift, err := auditHandler(client, handler, outputDir, owner, repo, vars)
+		results = append(results, handlerResult{
+			Handler:  handler.Name(),
+			Changes:  drift,
+			Err:      err,
+			Duration: time.Since(start),
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
+
+func auditHandler(client *gitea.Client, handler ConfigHandler, outputDir, owner, repo string, vars *repoVars) ([]Change, error) {
+	desired, err := loadHandlerData(handler, outputDir, owner, repo, vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data for handler %s: %w", handler.Name(), err)
+	}
+
+	live, err := handler.Pull(client, owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull %s for %s/%s: %w", handler.Name(), owner, repo, err)
+	}
+
+	return handler.Diff(live, desired)
+}
+
+var driftLabels = map[ChangeAction]string{
+	ChangeActionCreate: "missing",
+	ChangeActionUpdate: "differs",
+	ChangeActionDelete: "unexpected",
+}
+
+// printDrift writes the drift found in a repository and reports whether there was any
+func printDrift(out io.Writer, r repoResult) bool {
+	fmt.Fprintf(out, "\n📦 %s\n", r.Repo)
+	if len(r.Handlers) == 0 && r.Err != nil {
+		fmt.Fprintf(out, "  error: %v\n", r.Err)
+		return false
+	}
+
+	drifted := false
+	for _, hr := range r.Handlers {
+		switch {
+		case hr.Err != nil:
+			fmt.Fprintf(out, "  %s: error: %v\n", hr.Handler, hr.Err)
+			continue
+		case len(hr.Changes) == 0:
+			fmt.Fprintf(out, "  %s: in sync\n", hr.Handler)
+			continue
+		}
+
+		drifted = true
+		fmt.Fprintf(out, "  %s:\n", hr.Handler)
+		for _, c := range hr.Changes {
+			fmt.Fprintf(out, "    %s %s %s\n", changeSymbols[c.Action], driftLabels[c.Action], c.Item)
+			for _, f := range c.Fields {
+				live := f.From
+				if live == "" {
+					live = "(unset)"
+				}
+				fmt.Fprintf(out, "        %s: live %s, expected %s\n", f.Field, live, f.To)
+			}
+		}
+	}
+	return drifted
+}
+
+func init() {
+	auditCmd.Flags().Int("concurrency", 1,
+		"Number of repositories to audit in parallel (overrides concurrency in the config)")
+	addGroupFlag(auditCmd)
+	rootCmd.AddCommand(auditCmd)
+}
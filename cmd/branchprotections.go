@@ -3,37 +3,46 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
 
 	"code.gitea.io/sdk/gitea"
 	"gopkg.in/yaml.v3"
 )
 
-type BranchProtectionsHandler struct{}
+type BranchProtectionsHandler struct {
+	Config *Config
+	DryRun bool
+}
 
+// BranchProtection is a branch protection rule. Unset fields are nil, so the
+// merge strategy only changes the fields a rule sets; an empty list clears
+// the list.
 type BranchProtection struct {
 	BranchName                    string   `yaml:"branch_name"`
 	RuleName                      string   `yaml:"rule_name"`
-	EnablePush                    bool     `yaml:"enable_push"`
-	EnablePushWhitelist           bool     `yaml:"enable_push_whitelist"`
-	PushWhitelistUsernames        []string `yaml:"push_whitelist_usernames"`
-	PushWhitelistTeams            []string `yaml:"push_whitelist_teams"`
-	PushWhitelistDeployKeys       bool     `yaml:"push_whitelist_deploy_keys"`
-	EnableMergeWhitelist          bool     `yaml:"enable_merge_whitelist"`
-	MergeWhitelistUsernames       []string `yaml:"merge_whitelist_usernames"`
-	MergeWhitelistTeams           []string `yaml:"merge_whitelist_teams"`
-	EnableStatusCheck             bool     `yaml:"enable_status_check"`
-	StatusCheckContexts           []string `yaml:"status_check_contexts"`
-	RequiredApprovals             int64    `yaml:"required_approvals"`
-	EnableApprovalsWhitelist      bool     `yaml:"enable_approvals_whitelist"`
-	ApprovalsWhitelistUsernames   []string `yaml:"approvals_whitelist_usernames"`
-	ApprovalsWhitelistTeams       []string `yaml:"approvals_whitelist_teams"`
-	BlockOnRejectedReviews        bool     `yaml:"block_on_rejected_reviews"`
-	BlockOnOfficialReviewRequests bool     `yaml:"block_on_official_review_requests"`
-	BlockOnOutdatedBranch         bool     `yaml:"block_on_outdated_branch"`
-	DismissStaleApprovals         bool     `yaml:"dismiss_stale_approvals"`
-	RequireSignedCommits          bool     `yaml:"require_signed_commits"`
-	ProtectedFilePatterns         string   `yaml:"protected_file_patterns,omitempty"`
-	UnprotectedFilePatterns       string   `yaml:"unprotected_file_patterns,omitempty"`
+	EnablePush                    *bool    `yaml:"enable_push,omitempty"`
+	EnablePushWhitelist           *bool    `yaml:"enable_push_whitelist,omitempty"`
+	PushWhitelistUsernames        []string `yaml:"push_whitelist_usernames,omitempty"`
+	PushWhitelistTeams            []string `yaml:"push_whitelist_teams,omitempty"`
+	PushWhitelistDeployKeys       *bool    `yaml:"push_whitelist_deploy_keys,omitempty"`
+	EnableMergeWhitelist          *bool    `yaml:"enable_merge_whitelist,omitempty"`
+	MergeWhitelistUsernames       []string `yaml:"merge_whitelist_usernames,omitempty"`
+	MergeWhitelistTeams           []string `yaml:"merge_whitelist_teams,omitempty"`
+	EnableStatusCheck             *bool    `yaml:"enable_status_check,omitempty"`
+	StatusCheckContexts           []string `yaml:"status_check_contexts,omitempty"`
+	RequiredApprovals             *int64   `yaml:"required_approvals,omitempty"`
+	EnableApprovalsWhitelist      *bool    `yaml:"enable_approvals_whitelist,omitempty"`
+	ApprovalsWhitelistUsernames   []string `yaml:"approvals_whitelist_usernames,omitempty"`
+	ApprovalsWhitelistTeams       []string `yaml:"approvals_whitelist_teams,omitempty"`
+	BlockOnRejectedReviews        *bool    `yaml:"block_on_rejected_reviews,omitempty"`
+	BlockOnOfficialReviewRequests *bool    `yaml:"block_on_official_review_requests,omitempty"`
+	BlockOnOutdatedBranch         *bool    `yaml:"block_on_outdated_branch,omitempty"`
+	DismissStaleApprovals         *bool    `yaml:"dismiss_stale_approvals,omitempty"`
+	RequireSignedCommits          *bool    `yaml:"require_signed_commits,omitempty"`
+	ProtectedFilePatterns         *string  `yaml:"protected_file_patterns,omitempty"`
+	UnprotectedFilePatterns       *string  `yaml:"unprotected_file_patterns,omitempty"`
 }
 
 type BranchProtectionConfig struct {
@@ -49,112 +58,254 @@ func (h *BranchProtectionsHandler) Path() string {
 }
 
 func (h *BranchProtectionsHandler) Pull(client *gitea.Client, owner, repo string) (interface{}, error) {
-	protections, _, err := client.ListBranchProtections(owner, repo, gitea.ListBranchProtectionsOptions{})
+	protections, err := listBranchProtections(client, owner, repo)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list branch protections for %s/%s: %w", owner, repo, err)
 	}
 
 	transformedProtections := make([]BranchProtection, len(protections))
 	for i, bp := range protections {
-		transformedProtections[i] = BranchProtection{
-			BranchName:                    bp.BranchName,
-			RuleName:                      bp.RuleName,
-			EnablePush:                    bp.EnablePush,
-			EnablePushWhitelist:           bp.EnablePushWhitelist,
-			PushWhitelistUsernames:        bp.PushWhitelistUsernames,
-			PushWhitelistTeams:            bp.PushWhitelistTeams,
-			PushWhitelistDeployKeys:       bp.PushWhitelistDeployKeys,
-			EnableMergeWhitelist:          bp.EnableMergeWhitelist,
-			MergeWhitelistUsernames:       bp.MergeWhitelistUsernames,
-			MergeWhitelistTeams:           bp.MergeWhitelistTeams,
-			EnableStatusCheck:             bp.EnableStatusCheck,
-			StatusCheckContexts:           bp.StatusCheckContexts,
-			RequiredApprovals:             bp.RequiredApprovals,
-			EnableApprovalsWhitelist:      bp.EnableApprovalsWhitelist,
-			ApprovalsWhitelistUsernames:   bp.ApprovalsWhitelistUsernames,
-			ApprovalsWhitelistTeams:       bp.ApprovalsWhitelistTeams,
-			BlockOnRejectedReviews:        bp.BlockOnRejectedReviews,
-			BlockOnOfficialReviewRequests: bp.BlockOnOfficialReviewRequests,
-			BlockOnOutdatedBranch:         bp.BlockOnOutdatedBranch,
-			DismissStaleApprovals:         bp.DismissStaleApprovals,
-			RequireSignedCommits:          bp.RequireSignedCommits,
-			ProtectedFilePatterns:         bp.ProtectedFilePatterns,
-			UnprotectedFilePatterns:       bp.UnprotectedFilePatterns,
-		}
+		transformedProtections[i] = toBranchProtection(bp)
 	}
 
 	return BranchProtectionConfig{Rules: transformedProtections}, nil
 }
 
-func (h *BranchProtectionsHandler) Push(client *gitea.Client, owner, repo string, data interface{}) error {
-	bpConfig, ok := data.(BranchProtectionConfig)
-	if !ok {
-		return fmt.Errorf("invalid data type for BranchProtectionsHandler")
+func (h *BranchProtectionsHandler) Push(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error) {
+	changes, err := h.Plan(client, owner, repo, data)
+	if err != nil {
+		return nil, err
 	}
 
-	cfg, err := LoadConfig(cfgFile)
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+	return applyChanges(h.Name(), owner, repo, changes, h.DryRun)
+}
+
+func (h *BranchProtectionsHandler) Plan(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error) {
+	bpConfig, ok := data.(BranchProtectionConfig)
+	if !ok {
+		return nil, fmt.Errorf("invalid data type for BranchProtectionsHandler")
 	}
 
-	strategy := cfg.BranchProtectionsUpdateStrategy
+	strategy := h.Config.BranchProtectionsUpdateStrategy
 	if err := h.validateUpdateStrategy(strategy); err != nil {
-		return err
+		return nil, err
 	}
 
 	existing, err := h.getExistingProtectionsMap(client, owner, repo)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var changes []Change
 	if strategy == UpdateStrategyAppend {
 		for _, bp := range bpConfig.Rules {
-			if _, ok := existing[bp.RuleName]; ok {
+			if _, ok := existing[branchProtectionName(bp)]; ok {
 				continue
 			}
-
-			_, _, err := client.CreateBranchProtection(owner, repo, toCreateBranchProtectionOption(bp))
-			if err != nil {
-				return fmt.Errorf("failed to create protection: %w", err)
-			}
+			changes = append(changes, h.createChange(client, owner, repo, bp))
 		}
 
-		return nil
+		return changes, nil
 	}
 
 	if strategy == UpdateStrategyReplace {
-		for _, bp := range existing {
-			_, err := client.DeleteBranchProtection(owner, repo, bp.RuleName)
-			if err != nil {
-				return fmt.Errorf("failed to delete branch protection: %w", err)
-			}
-			delete(existing, bp.RuleName)
+		names := make([]string, 0, len(existing))
+		for name := range existing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			changes = append(changes, h.deleteChange(client, owner, repo, name))
+			delete(existing, name)
 		}
 	}
 
+	desired := make(map[string]bool, len(bpConfig.Rules))
 	for _, bp := range bpConfig.Rules {
-		if _, ok := existing[bp.BranchName]; ok {
-			_, _, err := client.EditBranchProtection(owner, repo, bp.RuleName, toEditBranchProtectionOption(bp))
-			if err != nil {
-				return fmt.Errorf("failed to update branch protection: %w", err)
+		name := branchProtectionName(bp)
+		desired[name] = true
+		if current, ok := existing[name]; ok {
+			live := toBranchProtection(current)
+			merged := mergeBranchProtection(live, bp)
+			fields := diffFields(live, merged)
+			if len(fields) > 0 {
+				changes = append(changes, Change{
+					Action: ChangeActionUpdate,
+					Item:   name,
+					Fields: fields,
+					apply: func() error {
+						_, _, err := client.EditBranchProtection(owner, repo, name, toEditBranchProtectionOption(merged))
+						if err != nil {
+							return fmt.Errorf("failed to update branch protection: %w", err)
+						}
+						return nil
+					},
+				})
 			}
 			continue
 		}
 
-		_, _, err := client.CreateBranchProtection(owner, repo, toCreateBranchProtectionOption(bp))
-		if err != nil {
-			return fmt.Errorf("failed to create branch protection: %w", err)
+		changes = append(changes, h.createChange(client, owner, repo, bp))
+	}
+
+	if strategy == UpdateStrategySync {
+		var names []string
+		for name := range existing {
+			if !desired[name] {
+				names = append(names, name)
+			}
 		}
+		sort.Strings(names)
 
+		for _, name := range names {
+			changes = append(changes, h.deleteChange(client, owner, repo, name))
+		}
+		changes = syncOrder(changes)
 	}
 
-	return nil
+	return changes, nil
+}
+
+func (h *BranchProtectionsHandler) Diff(live, desired interface{}) ([]Change, error) {
+	liveBP, ok := live.(BranchProtectionConfig)
+	desiredBP, ok2 := desired.(BranchProtectionConfig)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("invalid data type for BranchProtectionsHandler")
+	}
+
+	strategy := h.Config.BranchProtectionsUpdateStrategy
+	if err := h.validateUpdateStrategy(strategy); err != nil {
+		return nil, err
+	}
+
+	liveByName := make(map[string]BranchProtection, len(liveBP.Rules))
+	for _, bp := range liveBP.Rules {
+		liveByName[branchProtectionName(bp)] = bp
+	}
+
+	// like push with the merge strategy, fields a rule does not set are not
+	// compared
+	merged := make([]BranchProtection, len(desiredBP.Rules))
+	for i, bp := range desiredBP.Rules {
+		merged[i] = bp
+		if live, ok := liveByName[branchProtectionName(bp)]; ok {
+			merged[i] = mergeBranchProtection(live, bp)
+		}
+	}
+
+	return strategyChanges(diffItems(liveBP.Rules, merged, branchProtectionName), strategy), nil
+}
+
+// branchProtectionName returns the name a rule is identified by: its rule
+// name or, without one, its branch name
+func branchProtectionName(bp BranchProtection) string {
+	if bp.RuleName != "" {
+		return bp.RuleName
+	}
+	return bp.BranchName
+}
+
+// mergeBranchProtection returns live with the fields set in desired
+func mergeBranchProtection(live, desired BranchProtection) BranchProtection {
+	merged := live
+	mv := reflect.ValueOf(&merged).Elem()
+	dv := reflect.ValueOf(desired)
+	for i := 0; i < dv.NumField(); i++ {
+		f := dv.Field(i)
+		if f.IsZero() {
+			continue
+		}
+		mv.Field(i).Set(f)
+	}
+	return merged
+}
+
+func (h *BranchProtectionsHandler) createChange(client *gitea.Client, owner, repo string, bp BranchProtection) Change {
+	return Change{
+		Action: ChangeActionCreate,
+		Item:   branchProtectionName(bp),
+		apply: func() error {
+			_, _, err := client.CreateBranchProtection(owner, repo, toCreateBranchProtectionOption(bp))
+			if err != nil {
+				return fmt.Errorf("failed to create branch protection: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+func (h *BranchProtectionsHandler) deleteChange(client *gitea.Client, owner, repo, name string) Change {
+	return Change{
+		Action: ChangeActionDelete,
+		Item:   name,
+		apply: func() error {
+			if _, err := client.DeleteBranchProtection(owner, repo, name); err != nil {
+				return fmt.Errorf("failed to delete branch protection: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+func toBranchProtection(bp *gitea.BranchProtection) BranchProtection {
+	return BranchProtection{
+		BranchName:                    bp.BranchName,
+		RuleName:                      bp.RuleName,
+		EnablePush:                    ptr(bp.EnablePush),
+		EnablePushWhitelist:           ptr(bp.EnablePushWhitelist),
+		PushWhitelistUsernames:        bp.PushWhitelistUsernames,
+		PushWhitelistTeams:            bp.PushWhitelistTeams,
+		PushWhitelistDeployKeys:       ptr(bp.PushWhitelistDeployKeys),
+		EnableMergeWhitelist:          ptr(bp.EnableMergeWhitelist),
+		MergeWhitelistUsernames:       bp.MergeWhitelistUsernames,
+		MergeWhitelistTeams:           bp.MergeWhitelistTeams,
+		EnableStatusCheck:             ptr(bp.EnableStatusCheck),
+		StatusCheckContexts:           bp.StatusCheckContexts,
+		RequiredApprovals:             ptr(bp.RequiredApprovals),
+		EnableApprovalsWhitelist:      ptr(bp.EnableApprovalsWhitelist),
+		ApprovalsWhitelistUsernames:   bp.ApprovalsWhitelistUsernames,
+		ApprovalsWhitelistTeams:       bp.ApprovalsWhitelistTeams,
+		BlockOnRejectedReviews:        ptr(bp.BlockOnRejectedReviews),
+		BlockOnOfficialReviewRequests: ptr(bp.BlockOnOfficialReviewRequests),
+		BlockOnOutdatedBranch:         ptr(bp.BlockOnOutdatedBranch),
+		DismissStaleApprovals:         ptr(bp.DismissStaleApprovals),
+		RequireSignedCommits:          ptr(bp.RequireSignedCommits),
+		ProtectedFilePatterns:         ptr(bp.ProtectedFilePatterns),
+		UnprotectedFilePatterns:       ptr(bp.UnprotectedFilePatterns),
+	}
 }
 
 func toCreateBranchProtectionOption(bp BranchProtection) gitea.CreateBranchProtectionOption {
 	return gitea.CreateBranchProtectionOption{
 		BranchName:                    bp.BranchName,
 		RuleName:                      bp.RuleName,
+		EnablePush:                    deref(bp.EnablePush),
+		EnablePushWhitelist:           deref(bp.EnablePushWhitelist),
+		PushWhitelistUsernames:        bp.PushWhitelistUsernames,
+		PushWhitelistTeams:            bp.PushWhitelistTeams,
+		PushWhitelistDeployKeys:       deref(bp.PushWhitelistDeployKeys),
+		EnableMergeWhitelist:          deref(bp.EnableMergeWhitelist),
+		MergeWhitelistUsernames:       bp.MergeWhitelistUsernames,
+		MergeWhitelistTeams:           bp.MergeWhitelistTeams,
+		EnableStatusCheck:             deref(bp.EnableStatusCheck),
+		StatusCheckContexts:           bp.StatusCheckContexts,
+		RequiredApprovals:             deref(bp.RequiredApprovals),
+		EnableApprovalsWhitelist:      deref(bp.EnableApprovalsWhitelist),
+		ApprovalsWhitelistUsernames:   bp.ApprovalsWhitelistUsernames,
+		ApprovalsWhitelistTeams:       bp.ApprovalsWhitelistTeams,
+		BlockOnRejectedReviews:        deref(bp.BlockOnRejectedReviews),
+		BlockOnOfficialReviewRequests: deref(bp.BlockOnOfficialReviewRequests),
+		BlockOnOutdatedBranch:         deref(bp.BlockOnOutdatedBranch),
+		DismissStaleApprovals:         deref(bp.DismissStaleApprovals),
+		RequireSignedCommits:          deref(bp.RequireSignedCommits),
+		ProtectedFilePatterns:         deref(bp.ProtectedFilePatterns),
+		UnprotectedFilePatterns:       deref(bp.UnprotectedFilePatterns),
+	}
+}
+
+func toEditBranchProtectionOption(bp BranchProtection) gitea.EditBranchProtectionOption {
+	return gitea.EditBranchProtectionOption{
 		EnablePush:                    bp.EnablePush,
 		EnablePushWhitelist:           bp.EnablePushWhitelist,
 		PushWhitelistUsernames:        bp.PushWhitelistUsernames,
@@ -179,38 +330,12 @@ func toCreateBranchProtectionOption(bp BranchProtection) gitea.CreateBranchProte
 	}
 }
 
-func toEditBranchProtectionOption(bp BranchProtection) gitea.EditBranchProtectionOption {
-	return gitea.EditBranchProtectionOption{
-		EnablePush:                    &bp.EnablePush,
-		EnablePushWhitelist:           &bp.EnablePushWhitelist,
-		PushWhitelistUsernames:        bp.PushWhitelistUsernames,
-		PushWhitelistTeams:            bp.PushWhitelistTeams,
-		PushWhitelistDeployKeys:       &bp.PushWhitelistDeployKeys,
-		EnableMergeWhitelist:          &bp.EnableMergeWhitelist,
-		MergeWhitelistUsernames:       bp.MergeWhitelistUsernames,
-		MergeWhitelistTeams:           bp.MergeWhitelistTeams,
-		EnableStatusCheck:             &bp.EnableStatusCheck,
-		StatusCheckContexts:           bp.StatusCheckContexts,
-		RequiredApprovals:             &bp.RequiredApprovals,
-		EnableApprovalsWhitelist:      &bp.EnableApprovalsWhitelist,
-		ApprovalsWhitelistUsernames:   bp.ApprovalsWhitelistUsernames,
-		ApprovalsWhitelistTeams:       bp.ApprovalsWhitelistTeams,
-		BlockOnRejectedReviews:        &bp.BlockOnRejectedReviews,
-		BlockOnOfficialReviewRequests: &bp.BlockOnOfficialReviewRequests,
-		BlockOnOutdatedBranch:         &bp.BlockOnOutdatedBranch,
-		DismissStaleApprovals:         &bp.DismissStaleApprovals,
-		RequireSignedCommits:          &bp.RequireSignedCommits,
-		ProtectedFilePatterns:         &bp.ProtectedFilePatterns,
-		UnprotectedFilePatterns:       &bp.UnprotectedFilePatterns,
-	}
-}
-
 func (h *BranchProtectionsHandler) Enabled() bool {
 	return true
 }
 
 func (h *BranchProtectionsHandler) getExistingProtectionsMap(client *gitea.Client, owner, repo string) (map[string]*gitea.BranchProtection, error) {
-	protections, _, err := client.ListBranchProtections(owner, repo, gitea.ListBranchProtectionsOptions{})
+	protections, err := listBranchProtections(client, owner, repo)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list branch protections: %w", err)
 	}
@@ -223,6 +348,12 @@ func (h *BranchProtectionsHandler) getExistingProtectionsMap(client *gitea.Clien
 	return m, nil
 }
 
+func listBranchProtections(client *gitea.Client, owner, repo string) ([]*gitea.BranchProtection, error) {
+	return listAll(func(opts gitea.ListOptions) ([]*gitea.BranchProtection, *gitea.Response, error) {
+		return client.ListBranchProtections(owner, repo, gitea.ListBranchProtectionsOptions{ListOptions: opts})
+	})
+}
+
 func readBranchProtections(path string) (BranchProtectionConfig, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
@@ -235,6 +366,39 @@ func readBranchProtections(path string) (BranchProtectionConfig, error) {
 	return bpConfig, nil
 }
 
+func (h *BranchProtectionsHandler) render(data interface{}, vars *repoVars) (interface{}, error) {
+	bpConfig, ok := data.(BranchProtectionConfig)
+	if !ok {
+		return nil, fmt.Errorf("invalid data type for BranchProtectionsHandler")
+	}
+
+	rules := make([]BranchProtection, len(bpConfig.Rules))
+	for i, bp := range bpConfig.Rules {
+		if err := vars.renderAll(&bp.BranchName, &bp.RuleName); err != nil {
+			return nil, err
+		}
+		rules[i] = bp
+	}
+	return BranchProtectionConfig{Rules: rules}, nil
+}
+
+func (h *BranchProtectionsHandler) newData() interface{} {
+	return &BranchProtectionConfig{}
+}
+
+func (h *BranchProtectionsHandler) validateData(data interface{}) []fieldError {
+	var errs []fieldError
+	for i, bp := range data.(*BranchProtectionConfig).Rules {
+		if bp.BranchName == "" && bp.RuleName == "" {
+			errs = append(errs, fieldErr("branch_name or rule_name is required", "rules", strconv.Itoa(i)))
+		}
+		if bp.RequiredApprovals != nil && *bp.RequiredApprovals < 0 {
+			errs = append(errs, fieldErr("required_approvals must not be negative", "rules", strconv.Itoa(i), "required_approvals"))
+		}
+	}
+	return errs
+}
+
 func (h *BranchProtectionsHandler) Load(path string) (interface{}, error) {
 	return readBranchProtections(path)
 }
@@ -244,10 +408,11 @@ func (h *BranchProtectionsHandler) validateUpdateStrategy(strategy UpdateStrateg
 		UpdateStrategyReplace: true,
 		UpdateStrategyMerge:   true,
 		UpdateStrategyAppend:  true,
+		UpdateStrategySync:    true,
 	}
 
 	if _, ok := supported[strategy]; !ok {
-		return fmt.Errorf("invalid branch_protections_update_strategy: %s (must be 'replace', 'merge', or 'append')", strategy)
+		return fmt.Errorf("invalid branch_protections_update_strategy: %s (must be 'replace', 'merge', 'append' or 'sync')", strategy)
 	}
 
 	return nil
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeBranchProtection(t *testing.T) {
+	live := BranchProtection{
+		BranchName:           "main",
+		RuleName:             "main",
+		EnablePush:           ptr(true),
+		StatusCheckContexts:  []string{"build", "lint"},
+		RequiredApprovals:    ptr(int64(2)),
+		RequireSignedCommits: ptr(true),
+	}
+
+	tests := []struct {
+		name    string
+		desired BranchProtection
+		want    BranchProtection
+	}{
+		{
+			name:    "unset fields keep their live value",
+			desired: BranchProtection{RuleName: "main"},
+			want:    live,
+		},
+		{
+			name:    "set fields replace the live value",
+			desired: BranchProtection{RuleName: "main", RequiredApprovals: ptr(int64(1)), StatusCheckContexts: []string{"test"}},
+			want: BranchProtection{
+				BranchName:           "main",
+				RuleName:             "main",
+				EnablePush:           ptr(true),
+				StatusCheckContexts:  []string{"test"},
+				RequiredApprovals:    ptr(int64(1)),
+				RequireSignedCommits: ptr(true),
+			},
+		},
+		{
+			name:    "false and zero are set values",
+			desired: BranchProtection{RuleName: "main", EnablePush: ptr(false), RequiredApprovals: ptr(int64(0))},
+			want: BranchProtection{
+				BranchName:           "main",
+				RuleName:             "main",
+				EnablePush:           ptr(false),
+				StatusCheckContexts:  []string{"build", "lint"},
+				RequiredApprovals:    ptr(int64(0)),
+				RequireSignedCommits: ptr(true),
+			},
+		},
+		{
+			name:    "an empty list clears the live list",
+			desired: BranchProtection{RuleName: "main", StatusCheckContexts: []string{}},
+			want: BranchProtection{
+				BranchName:           "main",
+				RuleName:             "main",
+				EnablePush:           ptr(true),
+				StatusCheckContexts:  []string{},
+				RequiredApprovals:    ptr(int64(2)),
+				RequireSignedCommits: ptr(true),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeBranchProtection(live, tt.desired); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeBranchProtection() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBranchProtectionName(t *testing.T) {
+	tests := []struct {
+		bp   BranchProtection
+		want string
+	}{
+		{BranchProtection{BranchName: "main", RuleName: "protect-main"}, "protect-main"},
+		{BranchProtection{BranchName: "main"}, "main"},
+		{BranchProtection{RuleName: "release/*"}, "release/*"},
+	}
+
+	for _, tt := range tests {
+		if got := branchProtectionName(tt.bp); got != tt.want {
+			t.Errorf("branchProtectionName(%+v) = %q, want %q", tt.bp, got, tt.want)
+		}
+	}
+}
+
+func TestBranchProtectionsMergeWithOverride(t *testing.T) {
+	live := BranchProtectionConfig{Rules: []BranchProtection{{
+		BranchName:             "main",
+		EnableStatusCheck:      ptr(true),
+		StatusCheckContexts:    []string{"ci"},
+		EnablePushWhitelist:    ptr(true),
+		PushWhitelistUsernames: []string{"bot"},
+		RequiredApprovals:      ptr(int64(1)),
+	}}}
+
+	tests := []struct {
+		name     string
+		override string
+		want     []FieldChange
+	}{
+		{
+			name:     "unset lists keep their live value",
+			override: "rules:\n  - branch_name: main\n    required_approvals: 2\n",
+			want:     []FieldChange{{Field: "required_approvals", From: "1", To: "2"}},
+		},
+		{
+			name:     "an empty list clears the live list",
+			override: "rules:\n  - branch_name: main\n    status_check_contexts: []\n",
+			want:     []FieldChange{{Field: "status_check_contexts", From: `["ci"]`, To: "[]"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &BranchProtectionsHandler{Config: &Config{BranchProtectionsUpdateStrategy: UpdateStrategyMerge}}
+			dir := t.TempDir()
+			writeTestFile(t, filepath.Join(dir, h.Path()), "rules:\n  - branch_name: main\n    required_approvals: 1\n")
+			writeTestFile(t, filepath.Join(dir, DefaultOverridesDir, "org", "api", h.Path()), tt.override)
+
+			desired, err := loadMergedData(h, dir, "org", "api")
+			if err != nil {
+				t.Fatal(err)
+			}
+			changes, err := h.Diff(live, desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != 1 || changes[0].Action != ChangeActionUpdate {
+				t.Fatalf("changes = %+v, want one update", changes)
+			}
+			if !reflect.DeepEqual(changes[0].Fields, tt.want) {
+				t.Errorf("fields = %+v, want %+v", changes[0].Fields, tt.want)
+			}
+		})
+	}
+}
@@ -9,6 +9,8 @@ const (
 	DefaultWebhooksFile                 = "webhooks.yaml"
 	DefaultTopicsFile                   = "topics.yaml"
 	DefaultTemplatesFile                = "templates.yaml"
+	DefaultOverridesDir                 = "overrides"
+	DefaultPageSize                     = 50
 	DefaultTemplatesUpdateBranchName    = "gitea-config-wave/sync-templates"
 	DefaultTemplatesUpdateCommitMessage = "chore(docs): update PR and issue templates"
 	DefaultTemplatesUpdatePRDescription = `# Gitea Config Wave - Issue/PR Templates Sync
@@ -19,6 +21,9 @@ This PR is automatically created by [Gitea Config Wave](https://github.com/duals
 	DefaultTagProtectionsUpdateStrategy    = UpdateStrategyAppend
 	DefaultWebhooksUpdateStrategy          = UpdateStrategyAppend
 	DefaultTemplatesUpdateStrategy         = UpdateStrategyReplace
+
+	// ExitCodeDrift is returned by the audit command when live settings differ from the YAML
+	ExitCodeDrift = 2
 )
 
 type UpdateStrategy string
@@ -27,4 +32,7 @@ const (
 	UpdateStrategyReplace UpdateStrategy = "replace"
 	UpdateStrategyMerge   UpdateStrategy = "merge"
 	UpdateStrategyAppend  UpdateStrategy = "append"
+	// UpdateStrategySync updates matching items in place, creates missing ones
+	// and deletes the ones not in the settings file, in this order
+	UpdateStrategySync UpdateStrategy = "sync"
 )
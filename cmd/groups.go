@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// TargetGroup is a named set of target repos with its own settings. A group
+// inherits every top-level setting except targets and may override any of
+// them, e.g. output_dir, push toggles and update strategies.
+type TargetGroup struct {
+	Name      string
+	overrides yaml.Node
+}
+
+func (g *TargetGroup) UnmarshalYAML(node *yaml.Node) error {
+	var meta struct {
+		Name string `yaml:"name"`
+	}
+	if err := node.Decode(&meta); err != nil {
+		return err
+	}
+	if meta.Name == "" {
+		return fmt.Errorf("line %d: group without a name", node.Line)
+	}
+
+	g.Name = meta.Name
+	g.overrides = *node
+	return nil
+}
+
+func addGroupFlag(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("group", nil,
+		"Only process the repositories of these groups (can be repeated)")
+}
+
+// targetGroup is a set of repos pushed with the same config
+type targetGroup struct {
+	Name   string
+	Config *Config
+	Repos  []string
+}
+
+// groupConfig returns the config of a group: the top-level config without
+// its targets, overlaid with the settings of the group. The connection to
+// Gitea is shared by all groups.
+func (c *Config) groupConfig(g TargetGroup) (*Config, error) {
+	var gc Config
+	if err := c.source.Decode(&gc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	var empty Config
+	gc.Targets = empty.Targets
+	if err := g.overrides.Decode(&gc); err != nil {
+		return nil, fmt.Errorf("failed to parse group %q: %w", g.Name, err)
+	}
+
+	gc.GiteaURL = c.GiteaURL
+	gc.GiteaToken = c.GiteaToken
+	gc.Groups = nil
+	gc.source = c.source
+	return &gc, nil
+}
+
+// resolveTargetGroups returns the repos to process per group. Without groups
+// in the config, all targets form a single unnamed group.
+//
+// A repo that matches several groups belongs to the first of them in the
+// config, so every repo is processed with exactly one set of settings. Repos
+// given as arguments are looked up in the groups they belong to. If names is
+// not empty, only the groups with these names are returned.
+func resolveTargetGroups(
+	cmd *cobra.Command,
+	client *gitea.Client,
+	cfg *Config,
+	args []string,
+	names []string,
+) ([]targetGroup, error) {
+	if len(cfg.Groups) == 0 {
+		if len(names) > 0 {
+			return nil, fmt.Errorf("--group is set but no groups are defined in config")
+		}
+
+		repos, err := getAllTargetRepos(cmd, client, cfg, args)
+		if err != nil {
+			return nil, err
+		}
+		return []targetGroup{{Config: cfg, Repos: repos}}, nil
+	}
+
+	defined := make(map[string]bool, len(cfg.Groups))
+	for _, g := range cfg.Groups {
+		if defined[g.Name] {
+			return nil, fmt.Errorf("group %q is defined more than once", g.Name)
+		}
+		defined[g.Name] = true
+	}
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !defined[name] {
+			return nil, fmt.Errorf("unknown group %q", name)
+		}
+		selected[name] = true
+	}
+
+	requested := make(map[string]bool, len(args))
+	for _, arg := range args {
+		requested[strings.ToLower(arg)] = true
+	}
+
+	claimedBy := make(map[string]string)
+	var groups []targetGroup
+	for _, g := range cfg.Groups {
+		gc, err := cfg.groupConfig(g)
+		if err != nil {
+			return nil, err
+		}
+
+		repos, err := getAllTargetRepos(cmd, client, gc, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve targets of group %q: %w", g.Name, err)
+		}
+
+		group := targetGroup{Name: g.Name, Config: gc}
+		for _, repo := range repos {
+			key := strings.ToLower(repo)
+			if first, ok := claimedBy[key]; ok {
+				logger.Info("repository matches several groups, using the first",
+					"repo", repo, "group", first, "ignored_group", g.Name)
+				continue
+			}
+			claimedBy[key] = g.Name
+
+			if len(args) > 0 && !requested[key] {
+				continue
+			}
+			group.Repos = append(group.Repos, repo)
+		}
+
+		if len(names) == 0 || selected[g.Name] {
+			groups = append(groups, group)
+		}
+	}
+
+	for _, arg := range args {
+		if _, ok := claimedBy[strings.ToLower(arg)]; !ok {
+			return nil, fmt.Errorf("repository %q does not belong to any group", arg)
+		}
+	}
+
+	return groups, nil
+}
+
+// countRepos returns the number of repos across all groups
+func countRepos(groups []targetGroup) int {
+	var n int
+	for _, g := range groups {
+		n += len(g.Repos)
+	}
+	return n
+}
+
+// forEachGroupRepo calls fn for the repos of every group with the handlers
+// returned by newHandlers for that group, and returns the results together
+// with the names of all handlers in use. Groups without handlers are skipped.
+// If stopOnError is set, the repos of the groups after a failure are reported
+// as skipped.
+func forEachGroupRepo(
+	groups []targetGroup,
+	concurrency int,
+	stopOnError bool,
+	newHandlers func(cfg *Config) []ConfigHandler,
+	fn func(g targetGroup, handlers []ConfigHandler, fullName string) ([]handlerResult, error),
+) ([]repoResult, []string) {
+	var results []repoResult
+	var handlerNames []string
+	seen := make(map[string]bool)
+	failed := false
+
+	for _, g := range groups {
+		groupLogger := logger
+		if g.Name != "" {
+			groupLogger = logger.With("group", g.Name)
+		}
+
+		handlers := newHandlers(g.Config)
+		if len(handlers) == 0 {
+			groupLogger.Info("🤷 no items enabled in push config - nothing to do")
+			continue
+		}
+		for _, handler := range handlers {
+			if !seen[handler.Name()] {
+				seen[handler.Name()] = true
+				handlerNames = append(handlerNames, handler.Name())
+			}
+		}
+
+		if stopOnError && failed {
+			for _, repo := range g.Repos {
+				results = append(results, repoResult{Repo: repo, Skipped: true})
+			}
+			continue
+		}
+
+		groupLogger.Info("processing repositories", "count", len(g.Repos))
+
+		groupResults := forEachRepo(g.Repos, concurrency, stopOnError, func(fullName string) ([]handlerResult, error) {
+			return fn(g, handlers, fullName)
+		})
+		for _, r := range groupResults {
+			if r.Err != nil {
+				failed = true
+			}
+		}
+		results = append(results, groupResults...)
+	}
+
+	return results, handlerNames
+}
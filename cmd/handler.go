@@ -1,12 +1,231 @@
 package cmd
 
-import "code.gitea.io/sdk/gitea"
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"code.gitea.io/sdk/gitea"
+	"gopkg.in/yaml.v3"
+)
 
 type ConfigHandler interface {
 	Name() string
 	Path() string
 	Enabled() bool
 	Pull(client *gitea.Client, owner, repo string) (interface{}, error)
-	Push(client *gitea.Client, owner, repo string, data interface{}) error
+	// Push applies the data to the repo and returns the changes it made
+	Push(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error)
+	// Plan compares the loaded data with the live state of the repo and
+	// returns the changes Push would make under the configured update strategy.
+	Plan(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error)
+	// Diff compares the loaded data with live data as returned by Pull and
+	// returns the differences the configured update strategy would change.
+	Diff(live, desired interface{}) ([]Change, error)
 	Load(path string) (interface{}, error)
 }
+
+type ChangeAction string
+
+const (
+	ChangeActionCreate ChangeAction = "create"
+	ChangeActionUpdate ChangeAction = "update"
+	ChangeActionDelete ChangeAction = "delete"
+)
+
+// Change describes a single item a handler would create, update or delete.
+type Change struct {
+	Action ChangeAction
+	Item   string
+	Fields []FieldChange
+
+	// apply performs the API call for the change. It is nil for changes the
+	// handler applies in bulk, such as template files or replaced topics.
+	apply func() error
+}
+
+// FieldChange describes a single field of an updated item, with both values
+// rendered as JSON.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// applyChanges performs the API calls for the given changes in order and
+// returns the changes that were applied. In dry run mode the changes are only
+// logged.
+func applyChanges(handlerName, owner, repo string, changes []Change, dryRun bool) ([]Change, error) {
+	for i, c := range changes {
+		if dryRun {
+			logDryRunChange(handlerName, owner, repo, c)
+			continue
+		}
+
+		if c.apply == nil {
+			continue
+		}
+		if err := c.apply(); err != nil {
+			return changes[:i], err
+		}
+	}
+	return changes, nil
+}
+
+func logDryRunChange(handlerName, owner, repo string, c Change) {
+	args := []any{
+		"handler", handlerName,
+		"owner", owner,
+		"repo", repo,
+		"item", c.Item,
+	}
+	for _, f := range c.Fields {
+		args = append(args, f.Field, f.To)
+	}
+	logger.Info("(dry run) would "+string(c.Action), args...)
+}
+
+// strategyChanges returns the differences between live and desired items that
+// an update strategy acts on: append only creates missing items, merge also
+// updates existing ones, and replace and sync also delete the items that are
+// not in the settings.
+func strategyChanges(changes []Change, strategy UpdateStrategy) []Change {
+	var kept []Change
+	for _, c := range changes {
+		switch {
+		case c.Action == ChangeActionUpdate && strategy == UpdateStrategyAppend:
+		case c.Action == ChangeActionDelete && (strategy == UpdateStrategyAppend || strategy == UpdateStrategyMerge):
+		default:
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// syncOrder returns changes in the order the sync strategy applies them:
+// updates first, then creates and deletes last, so items are never missing
+// while they are being replaced
+func syncOrder(changes []Change) []Change {
+	rank := map[ChangeAction]int{
+		ChangeActionUpdate: 0,
+		ChangeActionCreate: 1,
+		ChangeActionDelete: 2,
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return rank[changes[i].Action] < rank[changes[j].Action]
+	})
+	return changes
+}
+
+// diffFields compares two values field by field using their YAML
+// representation. Only fields present in desired are compared, so unset
+// omitempty fields never show up as changes.
+func diffFields(live, desired interface{}) []FieldChange {
+	var desiredNode yaml.Node
+	if err := desiredNode.Encode(desired); err != nil || desiredNode.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	liveMap := map[string]interface{}{}
+	if b, err := yaml.Marshal(live); err == nil {
+		_ = yaml.Unmarshal(b, &liveMap)
+	}
+
+	var fields []FieldChange
+	for i := 0; i+1 < len(desiredNode.Content); i += 2 {
+		key := desiredNode.Content[i].Value
+
+		var want interface{}
+		if err := desiredNode.Content[i+1].Decode(&want); err != nil {
+			continue
+		}
+
+		have, ok := liveMap[key]
+		if ok && reflect.DeepEqual(have, want) {
+			continue
+		}
+
+		from := ""
+		if ok {
+			from = formatValue(have)
+		}
+		fields = append(fields, FieldChange{
+			Field: key,
+			From:  from,
+			To:    formatValue(want),
+		})
+	}
+	return append(fields, clearedLists(liveMap, desired)...)
+}
+
+// clearedLists returns the changes of the omitempty list fields desired sets
+// to an empty list. The YAML encoding leaves them out, so diffFields would
+// not compare them otherwise.
+func clearedLists(liveMap map[string]interface{}, desired interface{}) []FieldChange {
+	v := reflect.Indirect(reflect.ValueOf(desired))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fields []FieldChange
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() != reflect.Slice || f.IsNil() || f.Len() > 0 {
+			continue
+		}
+		key, opts, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if !strings.Contains(opts, "omitempty") {
+			continue
+		}
+		if have, ok := liveMap[key].([]interface{}); ok && len(have) > 0 {
+			fields = append(fields, FieldChange{
+				Field: key,
+				From:  formatValue(have),
+				To:    formatValue([]interface{}{}),
+			})
+		}
+	}
+	return fields
+}
+
+// diffItems compares two lists of items identified by key. Items only in
+// desired are reported as created, items only in live as deleted and items in
+// both with differing fields as updated.
+func diffItems[T any](live, desired []T, key func(T) string) []Change {
+	liveByKey := make(map[string]T, len(live))
+	for _, item := range live {
+		liveByKey[key(item)] = item
+	}
+
+	var changes []Change
+	desiredKeys := make(map[string]bool, len(desired))
+	for _, item := range desired {
+		k := key(item)
+		desiredKeys[k] = true
+
+		current, ok := liveByKey[k]
+		if !ok {
+			changes = append(changes, Change{Action: ChangeActionCreate, Item: k})
+			continue
+		}
+		if fields := diffFields(current, item); len(fields) > 0 {
+			changes = append(changes, Change{Action: ChangeActionUpdate, Item: k, Fields: fields})
+		}
+	}
+
+	for _, item := range live {
+		if k := key(item); !desiredKeys[k] {
+			changes = append(changes, Change{Action: ChangeActionDelete, Item: k})
+		}
+	}
+	return changes
+}
+
+func formatValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "?"
+	}
+	return string(b)
+}
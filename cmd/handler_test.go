@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffFields(t *testing.T) {
+	type settings struct {
+		Name    string   `yaml:"name"`
+		Enabled *bool    `yaml:"enabled,omitempty"`
+		Count   *int     `yaml:"count,omitempty"`
+		Labels  []string `yaml:"labels"`
+		Teams   []string `yaml:"teams,omitempty"`
+	}
+
+	tests := []struct {
+		name    string
+		live    interface{}
+		desired interface{}
+		want    []FieldChange
+	}{
+		{
+			name:    "equal",
+			live:    settings{Name: "a", Enabled: ptr(true), Labels: []string{"x"}},
+			desired: settings{Name: "a", Enabled: ptr(true), Labels: []string{"x"}},
+		},
+		{
+			name:    "unset omitempty fields are not compared",
+			live:    settings{Name: "a", Enabled: ptr(true), Count: ptr(3), Labels: []string{}},
+			desired: settings{Name: "a", Labels: []string{}},
+		},
+		{
+			name:    "changed fields with JSON values",
+			live:    settings{Name: "a", Enabled: ptr(true), Labels: []string{"x"}},
+			desired: settings{Name: "b", Enabled: ptr(false), Labels: []string{"x", "y"}},
+			want: []FieldChange{
+				{Field: "name", From: `"a"`, To: `"b"`},
+				{Field: "enabled", From: "true", To: "false"},
+				{Field: "labels", From: `["x"]`, To: `["x","y"]`},
+			},
+		},
+		{
+			name:    "field missing in live",
+			live:    settings{Name: "a", Labels: []string{}},
+			desired: settings{Name: "a", Count: ptr(2), Labels: []string{}},
+			want:    []FieldChange{{Field: "count", From: "", To: "2"}},
+		},
+		{
+			name:    "pointers are compared by value",
+			live:    &settings{Name: "a", Count: ptr(2)},
+			desired: &settings{Name: "a", Count: ptr(2)},
+		},
+		{
+			name:    "unset omitempty lists are not compared",
+			live:    settings{Name: "a", Teams: []string{"ops"}},
+			desired: settings{Name: "a"},
+		},
+		{
+			name:    "empty omitempty lists clear the live list",
+			live:    settings{Name: "a", Teams: []string{"ops"}},
+			desired: settings{Name: "a", Teams: []string{}},
+			want:    []FieldChange{{Field: "teams", From: `["ops"]`, To: "[]"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffFields(tt.live, tt.desired); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffFields() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffItems(t *testing.T) {
+	type item struct {
+		Name  string `yaml:"name"`
+		Value int    `yaml:"value"`
+	}
+	key := func(i item) string { return i.Name }
+
+	live := []item{{"a", 1}, {"b", 2}, {"c", 3}}
+	desired := []item{{"a", 1}, {"b", 5}, {"d", 4}}
+
+	want := []Change{
+		{Action: ChangeActionUpdate, Item: "b", Fields: []FieldChange{{Field: "value", From: "2", To: "5"}}},
+		{Action: ChangeActionCreate, Item: "d"},
+		{Action: ChangeActionDelete, Item: "c"},
+	}
+	if got := diffItems(live, desired, key); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffItems() = %+v, want %+v", got, want)
+	}
+
+	if got := diffItems(live, live, key); len(got) != 0 {
+		t.Errorf("diffItems() of equal lists = %+v, want none", got)
+	}
+}
+
+func TestSyncOrder(t *testing.T) {
+	changes := []Change{
+		{Action: ChangeActionDelete, Item: "old"},
+		{Action: ChangeActionCreate, Item: "new"},
+		{Action: ChangeActionUpdate, Item: "a"},
+		{Action: ChangeActionCreate, Item: "newer"},
+		{Action: ChangeActionUpdate, Item: "b"},
+	}
+
+	var got []string
+	for _, c := range syncOrder(changes) {
+		got = append(got, c.Item)
+	}
+	// the order within an action is kept
+	want := []string{"a", "b", "new", "newer", "old"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("syncOrder() = %v, want %v", got, want)
+	}
+}
+
+func TestStrategyChanges(t *testing.T) {
+	changes := []Change{
+		{Action: ChangeActionCreate, Item: "new"},
+		{Action: ChangeActionUpdate, Item: "changed"},
+		{Action: ChangeActionDelete, Item: "extra"},
+	}
+
+	tests := []struct {
+		strategy UpdateStrategy
+		want     []string
+	}{
+		{UpdateStrategyAppend, []string{"new"}},
+		{UpdateStrategyMerge, []string{"new", "changed"}},
+		{UpdateStrategyReplace, []string{"new", "changed", "extra"}},
+		{UpdateStrategySync, []string{"new", "changed", "extra"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			var got []string
+			for _, c := range strategyChanges(changes, tt.strategy) {
+				got = append(got, c.Item)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("strategyChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
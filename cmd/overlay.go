@@ -0,0 +1,280 @@
+package cmd
+
+// Per-repo overrides are YAML files at <output_dir>/overrides/<owner>/<repo>/
+// that have the same name as the base file they override. They only need to
+// contain the fields that differ for that repo.
+//
+// The overlay is deep-merged onto the base:
+//   - mappings are merged key by key
+//   - lists of mappings are merged item by item; items are matched by the
+//     first of rule_name, branch_name, name_pattern, path, url or config.url
+//     that both of them set and new items are appended
+//   - lists of scalars and scalars replace the base value
+//   - a key with a "+" suffix appends the missing items to the base list and
+//     a key with a "-" suffix removes items from it, e.g.
+//     "status_check_contexts+: [lint]" or "rules-: [legacy]"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// overlayIdentityKeys are the fields that identify an item in a list of
+// mappings, in order of precedence
+var overlayIdentityKeys = []string{"rule_name", "branch_name", "name_pattern", "path", "url"}
+
+// loadHandlerData loads the data of a handler for a repo: the base file in
+// outputDir merged with the override file of the repo, if there is one, with
+// the placeholders rendered for the repo.
+func loadHandlerData(handler ConfigHandler, outputDir, owner, repo string, vars *repoVars) (interface{}, error) {
+	data, err := loadMergedData(handler, outputDir, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	r, ok := handler.(renderer)
+	if !ok {
+		return data, nil
+	}
+	rendered, err := r.render(data, vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s for %s/%s: %w", handler.Name(), owner, repo, err)
+	}
+	return rendered, nil
+}
+
+func loadMergedData(handler ConfigHandler, outputDir, owner, repo string) (interface{}, error) {
+	basePath := filepath.Join(outputDir, handler.Path())
+	overlayPath := filepath.Join(outputDir, DefaultOverridesDir, owner, repo, handler.Path())
+
+	overlay, err := os.ReadFile(overlayPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return handler.Load(basePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read override file: %w", err)
+	}
+
+	// an override without a base file applies on its own
+	base, err := os.ReadFile(basePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return handler.Load(overlayPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read base file: %w", err)
+	}
+
+	like, err := handler.Load(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("applying override", "handler", handler.Name(), "repo", owner+"/"+repo, "file", overlayPath)
+	merged, err := applyOverlay(like, base, overlay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply override %s: %w", overlayPath, err)
+	}
+	return merged, nil
+}
+
+// applyOverlay deep-merges the overlay YAML onto the base YAML and decodes
+// the result as a value of the type of like. The files are merged as they are
+// written, so fields neither of them sets stay unset.
+func applyOverlay(like interface{}, base, overlay []byte) (interface{}, error) {
+	var baseTree map[string]interface{}
+	if err := yaml.Unmarshal(base, &baseTree); err != nil {
+		return nil, err
+	}
+
+	var overlayTree map[string]interface{}
+	if err := yaml.Unmarshal(overlay, &overlayTree); err != nil {
+		return nil, err
+	}
+
+	merged, err := yaml.Marshal(mergeMaps(baseTree, overlayTree))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeAs(like, func(out interface{}) error {
+		return yaml.Unmarshal(merged, out)
+	})
+}
+
+// decodeAs calls decode with a pointer to a new value of the type of like and
+// returns the decoded value, as a pointer if like is one
+func decodeAs(like interface{}, decode func(out interface{}) error) (interface{}, error) {
+	t := reflect.TypeOf(like)
+	isPtr := t.Kind() == reflect.Pointer
+	if isPtr {
+		t = t.Elem()
+	}
+	out := reflect.New(t)
+	if err := decode(out.Interface()); err != nil {
+		return nil, err
+	}
+	if isPtr {
+		return out.Interface(), nil
+	}
+	return out.Elem().Interface(), nil
+}
+
+func mergeMaps(base, overlay map[string]interface{}) map[string]interface{} {
+	if base == nil {
+		base = make(map[string]interface{})
+	}
+
+	for key, value := range overlay {
+		if name, ok := strings.CutSuffix(key, "+"); ok {
+			base[name] = appendItems(asList(base[name]), asList(value))
+			continue
+		}
+		if name, ok := strings.CutSuffix(key, "-"); ok {
+			base[name] = removeItems(asList(base[name]), asList(value))
+			continue
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if current, ok := base[key].(map[string]interface{}); ok {
+				base[key] = mergeMaps(current, v)
+				continue
+			}
+		case []interface{}:
+			if current, ok := base[key].([]interface{}); ok && isMappingList(v) {
+				base[key] = mergeLists(current, v)
+				continue
+			}
+		}
+		base[key] = value
+	}
+
+	return base
+}
+
+// mergeLists merges the items of overlay into the items of base that have
+// the same identity and appends the others
+func mergeLists(base, overlay []interface{}) []interface{} {
+	for _, item := range overlay {
+		m := item.(map[string]interface{})
+
+		merged := false
+		for i, current := range base {
+			if cm, ok := current.(map[string]interface{}); ok && sameOverlayItem(cm, m) {
+				base[i] = mergeMaps(cm, m)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			base = append(base, m)
+		}
+	}
+	return base
+}
+
+func appendItems(base, items []interface{}) []interface{} {
+	for _, item := range items {
+		if indexOfItem(base, item) < 0 {
+			base = append(base, item)
+		}
+	}
+	return base
+}
+
+func removeItems(base, items []interface{}) []interface{} {
+	result := make([]interface{}, 0, len(base))
+	for _, current := range base {
+		if indexOfItem(items, current) < 0 {
+			result = append(result, current)
+		}
+	}
+	return result
+}
+
+// indexOfItem returns the index of item in list. A scalar matches a mapping
+// with that identity, so mappings can be removed by name.
+func indexOfItem(list []interface{}, item interface{}) int {
+	for i, current := range list {
+		if reflect.DeepEqual(current, item) {
+			return i
+		}
+
+		cm, currentIsMap := current.(map[string]interface{})
+		im, itemIsMap := item.(map[string]interface{})
+		switch {
+		case currentIsMap && itemIsMap:
+			if sameOverlayItem(cm, im) {
+				return i
+			}
+		case currentIsMap:
+			if id := overlayIdentity(cm); id != "" && id == fmt.Sprint(item) {
+				return i
+			}
+		case itemIsMap:
+			if id := overlayIdentity(im); id != "" && id == fmt.Sprint(current) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// sameOverlayItem reports whether two mappings in a list are the same item.
+// They are compared by the first identity key both of them set, so an
+// override that only sets the branch_name of a branch protection matches the
+// base rule for that branch even if the rule has a rule_name. Otherwise they
+// are compared by their identity, which for branch protections is the rule
+// name or, without one, the branch name, like branchProtectionName.
+func sameOverlayItem(a, b map[string]interface{}) bool {
+	for _, key := range overlayIdentityKeys {
+		av, _ := a[key].(string)
+		bv, _ := b[key].(string)
+		if av != "" && bv != "" {
+			return av == bv
+		}
+	}
+	id := overlayIdentity(a)
+	return id != "" && id == overlayIdentity(b)
+}
+
+// overlayIdentity returns the value of the first identity key an item sets
+func overlayIdentity(m map[string]interface{}) string {
+	for _, key := range overlayIdentityKeys {
+		if v, ok := m[key].(string); ok && v != "" {
+			return v
+		}
+	}
+	if config, ok := m["config"].(map[string]interface{}); ok {
+		if v, ok := config["url"].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func isMappingList(list []interface{}) bool {
+	for _, item := range list {
+		if _, ok := item.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return len(list) > 0
+}
+
+func asList(v interface{}) []interface{} {
+	switch list := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return list
+	default:
+		return []interface{}{list}
+	}
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplyOverlay(t *testing.T) {
+	base := `rules:
+  - rule_name: main-protection
+    branch_name: main
+    required_approvals: 1
+    status_check_contexts: [build]
+  - branch_name: develop
+    required_approvals: 1
+  - rule_name: legacy
+    branch_name: legacy
+`
+	baseRules := []BranchProtection{
+		{RuleName: "main-protection", BranchName: "main", RequiredApprovals: ptr(int64(1)), StatusCheckContexts: []string{"build"}},
+		{BranchName: "develop", RequiredApprovals: ptr(int64(1))},
+		{RuleName: "legacy", BranchName: "legacy"},
+	}
+
+	tests := []struct {
+		name    string
+		overlay string
+		want    []BranchProtection
+	}{
+		{
+			name:    "branch_name matches a rule with a rule_name",
+			overlay: "rules:\n  - branch_name: main\n    required_approvals: 2\n",
+			want: []BranchProtection{
+				{RuleName: "main-protection", BranchName: "main", RequiredApprovals: ptr(int64(2)), StatusCheckContexts: []string{"build"}},
+				baseRules[1], baseRules[2],
+			},
+		},
+		{
+			name:    "rule_name matches a rule without one by its branch name",
+			overlay: "rules:\n  - rule_name: develop\n    required_approvals: 3\n",
+			want: []BranchProtection{
+				baseRules[0],
+				{BranchName: "develop", RuleName: "develop", RequiredApprovals: ptr(int64(3))},
+				baseRules[2],
+			},
+		},
+		{
+			name:    "different rule_name for the same branch is a new rule",
+			overlay: "rules:\n  - rule_name: main-strict\n    branch_name: main\n",
+			want: []BranchProtection{
+				baseRules[0], baseRules[1], baseRules[2],
+				{RuleName: "main-strict", BranchName: "main"},
+			},
+		},
+		{
+			name:    "append and remove list items",
+			overlay: "rules:\n  - rule_name: main-protection\n    status_check_contexts+: [lint, build]\nrules-: [legacy]\n",
+			want: []BranchProtection{
+				{RuleName: "main-protection", BranchName: "main", RequiredApprovals: ptr(int64(1)), StatusCheckContexts: []string{"build", "lint"}},
+				baseRules[1],
+			},
+		},
+		{
+			name:    "scalar list replaces the base list",
+			overlay: "rules:\n  - rule_name: main-protection\n    status_check_contexts: [test]\n",
+			want: []BranchProtection{
+				{RuleName: "main-protection", BranchName: "main", RequiredApprovals: ptr(int64(1)), StatusCheckContexts: []string{"test"}},
+				baseRules[1], baseRules[2],
+			},
+		},
+		{
+			name:    "empty list clears the base list",
+			overlay: "rules:\n  - rule_name: main-protection\n    status_check_contexts: []\n",
+			want: []BranchProtection{
+				{RuleName: "main-protection", BranchName: "main", RequiredApprovals: ptr(int64(1)), StatusCheckContexts: []string{}},
+				baseRules[1], baseRules[2],
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyOverlay(BranchProtectionConfig{}, []byte(base), []byte(tt.overlay))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := BranchProtectionConfig{Rules: tt.want}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("applyOverlay() =\n%#v\nwant\n%#v", got, want)
+			}
+			// lists set in neither file are still unset
+			for _, rule := range got.(BranchProtectionConfig).Rules {
+				if rule.PushWhitelistUsernames != nil || rule.MergeWhitelistTeams != nil {
+					t.Errorf("rule %s has whitelists set by the overlay", branchProtectionName(rule))
+				}
+			}
+		})
+	}
+}
+
+// TestLoadMergedData checks for every handler that fields neither the base
+// file nor the override sets are still unset after the override is applied
+func TestLoadMergedData(t *testing.T) {
+	cfg := &Config{}
+
+	tests := []struct {
+		handler  ConfigHandler
+		base     string
+		override string
+		want     interface{}
+	}{
+		{
+			handler:  &TopicsHandler{Config: cfg},
+			base:     "topics_remove: [legacy]\n",
+			override: "topics_remove+: [old]\n",
+			want:     TopicsConfig{TopicsRemove: []string{"legacy", "old"}},
+		},
+		{
+			handler:  &BranchProtectionsHandler{Config: cfg},
+			base:     "rules:\n  - branch_name: main\n    status_check_contexts: [ci]\n",
+			override: "rules:\n  - branch_name: main\n    required_approvals: 2\n",
+			want: BranchProtectionConfig{Rules: []BranchProtection{
+				{BranchName: "main", RequiredApprovals: ptr(int64(2)), StatusCheckContexts: []string{"ci"}},
+			}},
+		},
+		{
+			handler:  &TagProtectionsHandler{Config: cfg},
+			base:     "rules:\n  - name_pattern: v*\n    whitelist_teams: [release]\n",
+			override: "rules:\n  - name_pattern: release-*\n",
+			want: TagProtectionConfig{Rules: []TagProtection{
+				{NamePattern: "v*", WhitelistTeams: []string{"release"}},
+				{NamePattern: "release-*"},
+			}},
+		},
+		{
+			handler:  &WebhooksHandler{Config: cfg},
+			base:     "hooks:\n  - type: gitea\n    config:\n      url: https://ci.example.com\n",
+			override: "hooks:\n  - config:\n      url: https://ci.example.com\n    active: true\n",
+			want: WebhookConfig{Hooks: []Webhook{
+				{Type: "gitea", Config: map[string]string{"url": "https://ci.example.com"}, Active: true},
+			}},
+		},
+		{
+			handler:  &RepoSettingsHandler{Config: cfg},
+			base:     "has_issues: true\n",
+			override: "has_wiki: false\n",
+			want:     &RepoSettings{HasIssues: ptr(true), HasWiki: ptr(false)},
+		},
+		{
+			handler:  &TemplatesHandler{Config: cfg},
+			base:     "pr_templates:\n  - path: .gitea/pull_request_template.md\n    content: base\n",
+			override: "pr_templates:\n  - path: .gitea/pull_request_template.md\n    content: override\n",
+			want: TemplatesConfig{PRTemplates: []TemplateFile{
+				{Path: ".gitea/pull_request_template.md", Content: "override"},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.handler.Name(), func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFile(t, filepath.Join(dir, tt.handler.Path()), tt.base)
+			writeTestFile(t, filepath.Join(dir, DefaultOverridesDir, "org", "api", tt.handler.Path()), tt.override)
+
+			got, err := loadMergedData(tt.handler, dir, "org", "api")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadMergedData() =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeMaps(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    map[string]interface{}
+		overlay map[string]interface{}
+		want    map[string]interface{}
+	}{
+		{
+			name:    "nested mappings are merged",
+			base:    map[string]interface{}{"a": map[string]interface{}{"x": 1, "y": 2}, "b": true},
+			overlay: map[string]interface{}{"a": map[string]interface{}{"y": 3}},
+			want:    map[string]interface{}{"a": map[string]interface{}{"x": 1, "y": 3}, "b": true},
+		},
+		{
+			name:    "scalars replace the base value",
+			base:    map[string]interface{}{"a": "base"},
+			overlay: map[string]interface{}{"a": "overlay", "b": 1},
+			want:    map[string]interface{}{"a": "overlay", "b": 1},
+		},
+		{
+			name:    "webhooks are matched by config.url",
+			base:    map[string]interface{}{"hooks": []interface{}{map[string]interface{}{"type": "gitea", "active": true, "config": map[string]interface{}{"url": "https://a"}}}},
+			overlay: map[string]interface{}{"hooks": []interface{}{map[string]interface{}{"active": false, "config": map[string]interface{}{"url": "https://a"}}}},
+			want:    map[string]interface{}{"hooks": []interface{}{map[string]interface{}{"type": "gitea", "active": false, "config": map[string]interface{}{"url": "https://a"}}}},
+		},
+		{
+			name:    "items without identity are appended",
+			base:    map[string]interface{}{"rules": []interface{}{map[string]interface{}{"a": 1}}},
+			overlay: map[string]interface{}{"rules": []interface{}{map[string]interface{}{"a": 1}}},
+			want:    map[string]interface{}{"rules": []interface{}{map[string]interface{}{"a": 1}, map[string]interface{}{"a": 1}}},
+		},
+		{
+			name:    "append to a missing list",
+			base:    map[string]interface{}{},
+			overlay: map[string]interface{}{"topics+": []interface{}{"go"}},
+			want:    map[string]interface{}{"topics": []interface{}{"go"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeMaps(tt.base, tt.overlay); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeMaps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RegexPatternPrefix marks a repo pattern as a regular expression instead of a glob
+const RegexPatternPrefix = "re:"
+
+// RepoPatterns is a list of shell-style globs (e.g. "*-service") or, when
+// prefixed with "re:", regular expressions. In YAML it can be written as a
+// single string or as a list.
+type RepoPatterns []string
+
+func (p *RepoPatterns) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var single string
+		if err := node.Decode(&single); err != nil {
+			return err
+		}
+		if single == "" {
+			*p = nil
+		} else {
+			*p = RepoPatterns{single}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*p = list
+	return nil
+}
+
+// repoMatcher matches repo names against a set of RepoPatterns, ignoring case
+type repoMatcher struct {
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+func newRepoMatcher(patterns []string) (*repoMatcher, error) {
+	m := &repoMatcher{}
+	for _, p := range patterns {
+		if expr, ok := strings.CutPrefix(p, RegexPatternPrefix); ok {
+			re, err := regexp.Compile("(?i)" + expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %w", p, err)
+			}
+			m.regexps = append(m.regexps, re)
+			continue
+		}
+
+		glob := strings.ToLower(p)
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		m.globs = append(m.globs, glob)
+	}
+	return m, nil
+}
+
+// Empty reports whether the matcher has no patterns
+func (m *repoMatcher) Empty() bool {
+	return len(m.globs) == 0 && len(m.regexps) == 0
+}
+
+// Match reports whether name matches any of the patterns
+func (m *repoMatcher) Match(name string) bool {
+	lower := strings.ToLower(name)
+	for _, glob := range m.globs {
+		if ok, _ := path.Match(glob, lower); ok {
+			return true
+		}
+	}
+	for _, re := range m.regexps {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
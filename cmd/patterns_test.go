@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRepoMatcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		repo     string
+		want     bool
+	}{
+		{"glob", []string{"*-service"}, "payment-service", true},
+		{"glob does not match", []string{"*-service"}, "service-catalog", false},
+		{"glob ignores case", []string{"*-Service"}, "Payment-SERVICE", true},
+		{"glob against full name", []string{"MyOrg/*"}, "myorg/api", true},
+		{"glob does not cross slashes", []string{"*"}, "MyOrg/api", false},
+		{"regular expression", []string{"re:^api-v[0-9]+$"}, "api-v2", true},
+		{"regular expression ignores case", []string{"re:^api-"}, "API-gateway", true},
+		{"regular expression does not match", []string{"re:^api-v[0-9]+$"}, "api-v2-legacy", false},
+		{"any pattern of a list", []string{"web-*", "re:^api-"}, "api-gateway", true},
+		{"no patterns", nil, "api", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newRepoMatcher(tt.patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Match(tt.repo); got != tt.want {
+				t.Errorf("Match(%q) = %v, want %v", tt.repo, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepoMatcherInvalid(t *testing.T) {
+	for _, pattern := range []string{"[a-", "re:(unclosed"} {
+		if _, err := newRepoMatcher([]string{pattern}); err == nil {
+			t.Errorf("newRepoMatcher(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestRepoPatternsUnmarshal(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want RepoPatterns
+	}{
+		{`filter: "*-service"`, RepoPatterns{"*-service"}},
+		{`filter: ["a-*", "re:^b"]`, RepoPatterns{"a-*", "re:^b"}},
+		{`filter: ""`, nil},
+		{`filter: []`, RepoPatterns{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.yaml, func(t *testing.T) {
+			var source RepoSource
+			if err := yaml.Unmarshal([]byte(tt.yaml), &source); err != nil {
+				t.Fatal(err)
+			}
+			if len(source.Filter) != len(tt.want) || (tt.want == nil) != (source.Filter == nil) {
+				t.Fatalf("Filter = %#v, want %#v", source.Filter, tt.want)
+			}
+			for i := range tt.want {
+				if source.Filter[i] != tt.want[i] {
+					t.Errorf("Filter = %#v, want %#v", source.Filter, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/spf13/cobra"
+)
+
+// planCmd shows the changes push would make without applying them
+var planCmd = &cobra.Command{
+	Use:   "plan [owner/repo]...",
+	Short: "Show the changes push would make to Gitea repositories",
+	Long: `Compares the local repository settings with the live state of each
+target repository and prints the items that push would create, update
+or delete, grouped by repository and setting type. Nothing is changed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := LoadConfig(cfgFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		client, err := gitea.NewClient(cfg.GiteaURL, gitea.SetToken(cfg.GiteaToken))
+		if err != nil {
+			return fmt.Errorf("failed to create Gitea client: %w", err)
+		}
+
+		groupNames, err := cmd.Flags().GetStringSlice("group")
+		if err != nil {
+			return fmt.Errorf("could not parse --group flag: %w", err)
+		}
+
+		groups, err := resolveTargetGroups(cmd, client, cfg, args, groupNames)
+		if err != nil {
+			return err
+		}
+		if countRepos(groups) == 0 {
+			return errors.New("no repositories to process after merges/exclusions")
+		}
+
+		reportFile, reportFormat, err := reportFormatFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		startedAt := time.Now()
+		results, handlerNames := forEachGroupRepo(groups, 1, true,
+			func(groupCfg *Config) []ConfigHandler {
+				return pushHandlers(groupCfg, false)
+			},
+			func(g targetGroup, handlers []ConfigHandler, fullName string) ([]handlerResult, error) {
+				result := planRepo(out, client, g.Config, handlers, fullName)
+				return result.Handlers, result.Err
+			},
+		)
+
+		if len(handlerNames) == 0 {
+			return nil
+		}
+
+		counts := map[ChangeAction]int{}
+		var planErr error
+		for _, r := range results {
+			if r.Err != nil && planErr == nil {
+				planErr = r.Err
+			}
+			for _, hr := range r.Handlers {
+				for _, c := range hr.Changes {
+					counts[c.Action]++
+				}
+			}
+		}
+
+		if reportFile != "" {
+			report := newReport("plan", true, startedAt, results)
+			if err := writeReport(reportFile, reportFormat, report, results); err != nil {
+				return err
+			}
+		}
+
+		if planErr != nil {
+			return planErr
+		}
+
+		fmt.Fprintf(out, "\nPlan: %d to create, %d to update, %d to delete.\n",
+			counts[ChangeActionCreate],
+			counts[ChangeActionUpdate],
+			counts[ChangeActionDelete],
+		)
+		return nil
+	},
+}
+
+// planRepo prints the changes of every handler for a single repository. It
+// stops at the first handler that fails.
+func planRepo(out io.Writer, client *gitea.Client, cfg *Config, handlers []ConfigHandler, fullName string) repoResult {
+	result := repoResult{Repo: fullName}
+
+	owner, repo, err := parseRepoString(fullName)
+	if err != nil {
+		result.Err = fmt.Errorf("invalid repo argument %q: %w", fullName, err)
+		return result
+	}
+
+	outputDir := resolveOutputDir(cfg)
+	vars := newRepoVars(client, cfg, owner, repo)
+
+	fmt.Fprintf(out, "\n📦 %s\n", fullName)
+	for _, handler := range handlers {
+		if !handler.Enabled() {
+			continue
+		}
+
+		start := time.Now()
+		hr := handlerResult{Handler: handler.Name()}
+
+		data, err := loadHandlerData(handler, outputDir, owner, repo, vars)
+		if err != nil {
+			hr.Err = fmt.Errorf("failed to load data for handler %s: %w", handler.Name(), err)
+		} else {
+			hr.Changes, err = handler.Plan(client, owner, repo, data)
+			if err != nil {
+				hr.Err = fmt.Errorf("failed to plan %s for %s/%s: %w", handler.Name(), owner, repo, err)
+			}
+		}
+		hr.Duration = time.Since(start)
+		result.Handlers = append(result.Handlers, hr)
+
+		if hr.Err != nil {
+			result.Err = hr.Err
+			return result
+		}
+		printChanges(out, handler.Name(), hr.Changes)
+	}
+
+	return result
+}
+
+var changeSymbols = map[ChangeAction]string{
+	ChangeActionCreate: "+",
+	ChangeActionUpdate: "~",
+	ChangeActionDelete: "-",
+}
+
+func printChanges(out io.Writer, handlerName string, changes []Change) {
+	if len(changes) == 0 {
+		fmt.Fprintf(out, "  %s: no changes\n", handlerName)
+		return
+	}
+
+	fmt.Fprintf(out, "  %s:\n", handlerName)
+	for _, c := range changes {
+		fmt.Fprintf(out, "    %s %s %s\n", changeSymbols[c.Action], c.Action, c.Item)
+		for _, f := range c.Fields {
+			from := f.From
+			if from == "" {
+				from = "(unset)"
+			}
+			fmt.Fprintf(out, "        %s: %s -> %s\n", f.Field, from, f.To)
+		}
+	}
+}
+
+func init() {
+	addGroupFlag(planCmd)
+	addReportFlags(planCmd)
+	rootCmd.AddCommand(planCmd)
+}
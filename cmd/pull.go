@@ -45,22 +45,22 @@ saves them to YAML files in the output directory (defaults to .gitea/defaults).`
 		// Initialize handlers based on pull configuration
 		var handlers []ConfigHandler
 		if cfg.Pull.RepoSettings {
-			handlers = append(handlers, &RepoSettingsHandler{})
+			handlers = append(handlers, &RepoSettingsHandler{Config: cfg})
 		}
 		if cfg.Pull.Topics {
-			handlers = append(handlers, &TopicsHandler{})
+			handlers = append(handlers, &TopicsHandler{Config: cfg})
 		}
 		if cfg.Pull.BranchProtections {
-			handlers = append(handlers, &BranchProtectionsHandler{})
+			handlers = append(handlers, &BranchProtectionsHandler{Config: cfg})
 		}
 		if cfg.Pull.Webhooks {
-			handlers = append(handlers, &WebhooksHandler{})
+			handlers = append(handlers, &WebhooksHandler{Config: cfg})
+		}
+		if cfg.Pull.TagProtections {
+			handlers = append(handlers, &TagProtectionsHandler{Config: cfg})
 		}
-		// if cfg.Pull.TagProtections {
-		// 	handlers = append(handlers, &TagProtectionsHandler{})
-		// }
 		if cfg.Pull.Templates {
-			handlers = append(handlers, &TemplatesHandler{})
+			handlers = append(handlers, &TemplatesHandler{Config: cfg})
 		}
 
 		if len(handlers) == 0 {
@@ -68,10 +68,7 @@ saves them to YAML files in the output directory (defaults to .gitea/defaults).`
 			return nil
 		}
 
-		outputDir := cfg.Config.OutputDir
-		if outputDir == "" {
-			outputDir = DefaultOutputDir
-		}
+		outputDir := resolveOutputDir(cfg)
 
 		if dryRun {
 			logger.Info("would pull repository settings (dry run)",
@@ -102,6 +99,12 @@ saves them to YAML files in the output directory (defaults to .gitea/defaults).`
 				return fmt.Errorf("failed to pull %s: %w", handler.Name(), err)
 			}
 
+			if p, ok := handler.(sharedPuller); ok {
+				if data, err = p.shared(data); err != nil {
+					return fmt.Errorf("failed to pull %s: %w", handler.Name(), err)
+				}
+			}
+
 			outputPath := filepath.Join(outputDir, handler.Path())
 			if err := WriteYAMLFile(outputPath, data); err != nil {
 				return fmt.Errorf("failed to write %s: %w", handler.Name(), err)
@@ -117,6 +120,13 @@ saves them to YAML files in the output directory (defaults to .gitea/defaults).`
 	},
 }
 
+// sharedPuller is implemented by handlers whose pulled data contains settings
+// that are specific to the source repository. shared returns the data to write
+// to the settings files, which are shared by all target repositories.
+type sharedPuller interface {
+	shared(data interface{}) (interface{}, error)
+}
+
 func init() {
 	rootCmd.AddCommand(pullCmd)
 }
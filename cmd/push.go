@@ -3,7 +3,9 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"path/filepath"
+	"io"
+	"text/tabwriter"
+	"time"
 
 	"code.gitea.io/sdk/gitea"
 	"github.com/spf13/cobra"
@@ -22,6 +24,10 @@ in the config file or in the command arguments.`,
 			return fmt.Errorf("could not parse --dry-run flag: %w", err)
 		}
 
+		if err := validateConfigFiles(cfgFile); err != nil {
+			return err
+		}
+
 		cfg, err := LoadConfig(cfgFile)
 		if err != nil {
 			return fmt.Errorf("failed to load config: %w", err)
@@ -32,107 +38,281 @@ in the config file or in the command arguments.`,
 			return fmt.Errorf("failed to create Gitea client: %w", err)
 		}
 
-		targetRepos, err := getAllTargetRepos(cmd, client, cfg, args)
+		groupNames, err := cmd.Flags().GetStringSlice("group")
+		if err != nil {
+			return fmt.Errorf("could not parse --group flag: %w", err)
+		}
+
+		groups, err := resolveTargetGroups(cmd, client, cfg, args, groupNames)
 		if err != nil {
 			return err
 		}
-		if len(targetRepos) == 0 {
+		if countRepos(groups) == 0 {
 			return errors.New("no repositories to process after merges/exclusions")
 		}
 
-		logger.Info("found target repositories", "count", len(targetRepos))
-		for _, repo := range targetRepos {
-			logger.Info("📦 " + repo)
+		logger.Info("found target repositories", "count", countRepos(groups))
+		for _, g := range groups {
+			for _, repo := range g.Repos {
+				if g.Name != "" {
+					logger.Info("📦 "+repo, "group", g.Name)
+					continue
+				}
+				logger.Info("📦 " + repo)
+			}
 		}
 
-		outputDir := cfg.Config.OutputDir
-		if outputDir == "" {
-			outputDir = DefaultOutputDir
+		dryRun = dryRun || cfg.DryRun
+
+		concurrency, err := concurrencyFromFlags(cmd, cfg)
+		if err != nil {
+			return err
 		}
 
-		// Initialize handlers based on push configuration
-		var handlers []ConfigHandler
-		if cfg.Push.RepoSettings {
-			handlers = append(handlers, &RepoSettingsHandler{})
+		continueOnError, err := cmd.Flags().GetBool("continue-on-error")
+		if err != nil {
+			return fmt.Errorf("could not parse --continue-on-error flag: %w", err)
 		}
-		if cfg.Push.Topics {
-			handlers = append(handlers, &TopicsHandler{})
+		continueOnError = continueOnError || cfg.ContinueOnError
+
+		reportFile, reportFormat, err := reportFormatFromFlags(cmd)
+		if err != nil {
+			return err
 		}
-		if cfg.Push.BranchProtections {
-			handlers = append(handlers, &BranchProtectionsHandler{})
+
+		startedAt := time.Now()
+		results, handlerNames := forEachGroupRepo(groups, concurrency, !continueOnError,
+			// a group can be a dry run on its own, e.g. to try out new settings
+			func(groupCfg *Config) []ConfigHandler {
+				return pushHandlers(groupCfg, dryRun || groupCfg.DryRun)
+			},
+			func(g targetGroup, handlers []ConfigHandler, fullName string) ([]handlerResult, error) {
+				return pushRepo(client, g.Config, handlers, fullName, dryRun || g.Config.DryRun, continueOnError)
+			},
+		)
+
+		if len(handlerNames) == 0 {
+			return nil
 		}
-		if cfg.Push.Webhooks {
-			handlers = append(handlers, &WebhooksHandler{})
+
+		printPushSummary(cmd.OutOrStdout(), handlerNames, results)
+
+		if reportFile != "" {
+			report := newReport("push", dryRun, startedAt, results)
+			if err := writeReport(reportFile, reportFormat, report, results); err != nil {
+				return err
+			}
 		}
 
-		// TODO: Not supported yet
-		// if cfg.Push.TagProtections {
-		// 	handlers = append(handlers, &TagProtectionsHandler{})
-		// }
+		var failed int
+		var firstErr error
+		for _, r := range results {
+			if r.Err != nil {
+				failed++
+				if firstErr == nil {
+					firstErr = r.Err
+				}
+			}
+		}
 
-		if cfg.Push.Templates {
-			handlers = append(handlers, &TemplatesHandler{})
+		if failed > 0 && continueOnError {
+			return fmt.Errorf("failed to push settings to %d of %d repositories", failed, len(results))
 		}
+		return firstErr
+	},
+}
 
-		if len(handlers) == 0 {
-			logger.Info("🤷 no items enabled in push config - nothing to do")
-			return nil
+// pushRepo pushes the data of every handler to a single repository. Unless
+// continueOnError is set, the remaining handlers are skipped after a failure.
+func pushRepo(
+	client *gitea.Client,
+	cfg *Config,
+	handlers []ConfigHandler,
+	fullName string,
+	dryRun, continueOnError bool,
+) ([]handlerResult, error) {
+	owner, repo, err := parseRepoString(fullName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repo argument %q: %w", fullName, err)
+	}
+
+	outputDir := resolveOutputDir(cfg)
+	vars := newRepoVars(client, cfg, owner, repo)
+
+	repoLogger := logger.With("repo", fullName)
+	results := make([]handlerResult, 0, len(handlers))
+	var errs []error
+	for _, handler := range handlers {
+		if !handler.Enabled() {
+			continue
 		}
 
-		for _, fullName := range targetRepos {
-			owner, repo, err := parseRepoString(fullName)
-			if err != nil {
-				return fmt.Errorf("invalid repo argument %q: %w", fullName, err)
-			}
+		if len(errs) > 0 && !continueOnError {
+			results = append(results, handlerResult{Handler: handler.Name(), Skipped: true})
+			continue
+		}
 
-			if dryRun || cfg.DryRun {
-				logger.Info("(dry run) will apply settings to",
-					"owner", owner,
-					"repo", repo,
-				)
-				continue
-			}
+		repoLogger.Debug("processing handler", "handler", handler.Name())
 
-			for _, handler := range handlers {
-				if !handler.Enabled() {
-					continue
-				}
+		start := time.Now()
+		changes, err := pushHandler(client, handler, outputDir, owner, repo, vars)
+		results = append(results, handlerResult{
+			Handler:  handler.Name(),
+			Changes:  changes,
+			Err:      err,
+			Duration: time.Since(start),
+		})
+		if err != nil {
+			repoLogger.Error("failed to push settings", "handler", handler.Name(), "error", err)
+			errs = append(errs, err)
+			continue
+		}
 
-				logger.Debug("processing handler",
-					"handler", handler.Name(),
-					"owner", owner,
-					"repo", repo,
-				)
+		repoLogger.Debug("successfully processed handler", "handler", handler.Name())
+	}
 
-				// Load handler data from file
-				data, err := handler.Load(filepath.Join(outputDir, handler.Path()))
-				if err != nil {
-					return fmt.Errorf("failed to load data for handler %s: %w", handler.Name(), err)
-				}
+	if len(errs) > 0 {
+		return results, errors.Join(errs...)
+	}
 
-				// Push changes using handler
-				err = handler.Push(client, owner, repo, data)
-				if err != nil {
-					return fmt.Errorf("failed to push %s for %s/%s: %w", handler.Name(), owner, repo, err)
-				}
+	if dryRun {
+		repoLogger.Info("(dry run) no changes were made")
+		return results, nil
+	}
+
+	repoLogger.Info("successfully pushed settings")
+	return results, nil
+}
+
+func pushHandler(client *gitea.Client, handler ConfigHandler, outputDir, owner, repo string, vars *repoVars) ([]Change, error) {
+	// Load handler data from file, with the overrides and variables of the repo applied
+	data, err := loadHandlerData(handler, outputDir, owner, repo, vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data for handler %s: %w", handler.Name(), err)
+	}
+
+	// Push changes using handler
+	changes, err := handler.Push(client, owner, repo, data)
+	if err != nil {
+		return changes, fmt.Errorf("failed to push %s for %s/%s: %w", handler.Name(), owner, repo, err)
+	}
+	return changes, nil
+}
+
+// printPushSummary writes a table of succeeded, failed and skipped handlers
+// followed by the list of failures
+func printPushSummary(out io.Writer, handlerNames []string, results []repoResult) {
+	type counts struct{ succeeded, failed, skipped int }
+	byHandler := make(map[string]*counts, len(handlerNames))
+	for _, name := range handlerNames {
+		byHandler[name] = &counts{}
+	}
 
-				logger.Debug("successfully processed handler",
-					"handler", handler.Name(),
-					"owner", owner,
-					"repo", repo,
-				)
+	var repos counts
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+			repos.skipped++
+			for _, c := range byHandler {
+				c.skipped++
 			}
+			continue
+		case r.Err != nil:
+			repos.failed++
+		default:
+			repos.succeeded++
+		}
 
-			logger.Info("successfully pushed settings",
-				"owner", owner,
-				"repo", repo,
-			)
+		for _, hr := range r.Handlers {
+			c := byHandler[hr.Handler]
+			switch {
+			case hr.Skipped:
+				c.skipped++
+			case hr.Err != nil:
+				c.failed++
+			default:
+				c.succeeded++
+			}
 		}
+	}
 
-		return nil
-	},
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nHANDLER\tSUCCEEDED\tFAILED\tSKIPPED")
+	for _, name := range handlerNames {
+		c := byHandler[name]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", name, c.succeeded, c.failed, c.skipped)
+	}
+	fmt.Fprintf(w, "repositories\t%d\t%d\t%d\n", repos.succeeded, repos.failed, repos.skipped)
+	w.Flush()
+
+	if repos.failed == 0 {
+		return
+	}
+
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nFAILED REPOSITORY\tHANDLER\tERROR")
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		if len(r.Handlers) == 0 {
+			fmt.Fprintf(w, "%s\t-\t%v\n", r.Repo, r.Err)
+			continue
+		}
+		for _, hr := range r.Handlers {
+			if hr.Err != nil {
+				fmt.Fprintf(w, "%s\t%s\t%v\n", r.Repo, hr.Handler, hr.Err)
+			}
+		}
+	}
+	w.Flush()
+}
+
+// concurrencyFromFlags returns the --concurrency flag if it was set and the
+// concurrency from the config otherwise
+func concurrencyFromFlags(cmd *cobra.Command, cfg *Config) (int, error) {
+	if !cmd.Flags().Changed("concurrency") {
+		return cfg.Concurrency, nil
+	}
+
+	concurrency, err := cmd.Flags().GetInt("concurrency")
+	if err != nil {
+		return 0, fmt.Errorf("could not parse --concurrency flag: %w", err)
+	}
+	return concurrency, nil
+}
+
+// pushHandlers returns the handlers enabled in the push section of the config.
+// In dry run mode the handlers only log the changes they would make.
+func pushHandlers(cfg *Config, dryRun bool) []ConfigHandler {
+	var handlers []ConfigHandler
+	if cfg.Push.RepoSettings {
+		handlers = append(handlers, &RepoSettingsHandler{Config: cfg, DryRun: dryRun})
+	}
+	if cfg.Push.Topics {
+		handlers = append(handlers, &TopicsHandler{Config: cfg, DryRun: dryRun})
+	}
+	if cfg.Push.BranchProtections {
+		handlers = append(handlers, &BranchProtectionsHandler{Config: cfg, DryRun: dryRun})
+	}
+	if cfg.Push.Webhooks {
+		handlers = append(handlers, &WebhooksHandler{Config: cfg, DryRun: dryRun})
+	}
+	if cfg.Push.TagProtections {
+		handlers = append(handlers, &TagProtectionsHandler{Config: cfg, DryRun: dryRun})
+	}
+	if cfg.Push.Templates {
+		handlers = append(handlers, &TemplatesHandler{Config: cfg, DryRun: dryRun})
+	}
+	return handlers
 }
 
 func init() {
+	pushCmd.Flags().Int("concurrency", 1,
+		"Number of repositories to push to in parallel (overrides concurrency in the config)")
+	pushCmd.Flags().Bool("continue-on-error", false,
+		"Keep pushing to the remaining repositories when one fails and report all failures at the end")
+	addGroupFlag(pushCmd)
+	addReportFlags(pushCmd)
 	rootCmd.AddCommand(pushCmd)
 }
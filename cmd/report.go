@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type ReportFormat string
+
+const (
+	ReportFormatJSON  ReportFormat = "json"
+	ReportFormatJUnit ReportFormat = "junit"
+)
+
+// Report is the machine-readable outcome of a push or plan run
+type Report struct {
+	Command   string         `json:"command"`
+	DryRun    bool           `json:"dry_run"`
+	StartedAt time.Time      `json:"started_at"`
+	Duration  float64        `json:"duration_seconds"`
+	Records   []ReportRecord `json:"records"`
+}
+
+// ReportRecord describes one change made to a repo by a handler. A handler
+// without changes is reported once as unchanged, a failed or skipped handler
+// once as failed or skipped.
+type ReportRecord struct {
+	Repo     string        `json:"repo"`
+	Handler  string        `json:"handler"`
+	Action   string        `json:"action"`
+	Item     string        `json:"item,omitempty"`
+	Fields   []FieldChange `json:"fields,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration float64       `json:"duration_seconds"`
+}
+
+var reportActions = map[ChangeAction]string{
+	ChangeActionCreate: "created",
+	ChangeActionUpdate: "updated",
+	ChangeActionDelete: "deleted",
+}
+
+func addReportFlags(cmd *cobra.Command) {
+	cmd.Flags().String("report-file", "",
+		"Write a machine-readable report of the run to this file")
+	cmd.Flags().String("report-format", "",
+		"Format of the report file: json or junit (default: junit for .xml files, json otherwise)")
+}
+
+// reportFormatFromFlags returns the report file and format requested on the
+// command line. The file is empty if no report was requested.
+func reportFormatFromFlags(cmd *cobra.Command) (string, ReportFormat, error) {
+	file, err := cmd.Flags().GetString("report-file")
+	if err != nil {
+		return "", "", fmt.Errorf("could not parse --report-file flag: %w", err)
+	}
+	format, err := cmd.Flags().GetString("report-format")
+	if err != nil {
+		return "", "", fmt.Errorf("could not parse --report-format flag: %w", err)
+	}
+
+	if format == "" {
+		format = string(ReportFormatJSON)
+		if strings.EqualFold(filepath.Ext(file), ".xml") {
+			format = string(ReportFormatJUnit)
+		}
+	}
+
+	switch ReportFormat(format) {
+	case ReportFormatJSON, ReportFormatJUnit:
+		return file, ReportFormat(format), nil
+	default:
+		return "", "", fmt.Errorf("invalid --report-format %q (must be 'json' or 'junit')", format)
+	}
+}
+
+func newReport(command string, dryRun bool, startedAt time.Time, results []repoResult) Report {
+	report := Report{
+		Command:   command,
+		DryRun:    dryRun,
+		StartedAt: startedAt,
+		Duration:  time.Since(startedAt).Seconds(),
+		Records:   []ReportRecord{},
+	}
+
+	for _, r := range results {
+		if len(r.Handlers) == 0 && (r.Skipped || r.Err != nil) {
+			record := ReportRecord{Repo: r.Repo, Action: "skipped"}
+			if r.Err != nil {
+				record.Action = "failed"
+				record.Error = r.Err.Error()
+			}
+			report.Records = append(report.Records, record)
+			continue
+		}
+
+		for _, hr := range r.Handlers {
+			base := ReportRecord{
+				Repo:     r.Repo,
+				Handler:  hr.Handler,
+				Duration: hr.Duration.Seconds(),
+			}
+
+			for _, c := range hr.Changes {
+				record := base
+				record.Action = reportActions[c.Action]
+				record.Item = c.Item
+				record.Fields = c.Fields
+				report.Records = append(report.Records, record)
+			}
+
+			switch {
+			case hr.Skipped:
+				base.Action = "skipped"
+			case hr.Err != nil:
+				base.Action = "failed"
+				base.Error = hr.Err.Error()
+			case len(hr.Changes) == 0:
+				base.Action = "unchanged"
+			default:
+				continue
+			}
+			report.Records = append(report.Records, base)
+		}
+	}
+
+	return report
+}
+
+// writeReport writes the results of a run to file in the given format
+func writeReport(file string, format ReportFormat, report Report, results []repoResult) error {
+	var data []byte
+	var err error
+	switch format {
+	case ReportFormatJUnit:
+		data, err = xml.MarshalIndent(newJUnitReport(report, results), "", "  ")
+		if err == nil {
+			data = append([]byte(xml.Header), data...)
+		}
+	default:
+		data, err = json.MarshalIndent(report, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	if err := os.WriteFile(file, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	logger.Info("wrote report", "file", file, "format", format)
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// newJUnitReport maps every repo to a test suite and every handler run
+// against it to a test case, with the changes listed in system-out.
+func newJUnitReport(report Report, results []repoResult) junitTestSuites {
+	suites := junitTestSuites{
+		Name: "gitea-config-wave " + report.Command,
+		Time: fmt.Sprintf("%.3f", report.Duration),
+	}
+
+	for _, r := range results {
+		suite := junitTestSuite{Name: r.Repo}
+		var suiteTime time.Duration
+
+		if len(r.Handlers) == 0 && (r.Skipped || r.Err != nil) {
+			tc := junitTestCase{Name: report.Command, ClassName: r.Repo}
+			if r.Err != nil {
+				tc.Failure = &junitFailure{Message: r.Err.Error(), Text: r.Err.Error()}
+			} else {
+				tc.Skipped = &struct{}{}
+			}
+			suite.TestCases = append(suite.TestCases, tc)
+		}
+
+		for _, hr := range r.Handlers {
+			tc := junitTestCase{
+				Name:      hr.Handler,
+				ClassName: r.Repo,
+				Time:      fmt.Sprintf("%.3f", hr.Duration.Seconds()),
+			}
+
+			var out strings.Builder
+			for _, c := range hr.Changes {
+				fmt.Fprintf(&out, "%s %s\n", reportActions[c.Action], c.Item)
+				for _, f := range c.Fields {
+					fmt.Fprintf(&out, "  %s: %s -> %s\n", f.Field, f.From, f.To)
+				}
+			}
+			if out.Len() == 0 && hr.Err == nil && !hr.Skipped {
+				out.WriteString("unchanged\n")
+			}
+			tc.SystemOut = out.String()
+
+			switch {
+			case hr.Skipped:
+				tc.Skipped = &struct{}{}
+			case hr.Err != nil:
+				tc.Failure = &junitFailure{Message: hr.Err.Error(), Text: hr.Err.Error()}
+			}
+			suite.TestCases = append(suite.TestCases, tc)
+			suiteTime += hr.Duration
+		}
+
+		suite.Time = fmt.Sprintf("%.3f", suiteTime.Seconds())
+		for _, tc := range suite.TestCases {
+			suite.Tests++
+			if tc.Failure != nil {
+				suite.Failures++
+			}
+			if tc.Skipped != nil {
+				suite.Skipped++
+			}
+		}
+
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	return suites
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testResults() []repoResult {
+	return []repoResult{
+		{
+			Repo: "org/api",
+			Handlers: []handlerResult{
+				{
+					Handler: "topics",
+					Changes: []Change{{
+						Action: ChangeActionUpdate,
+						Item:   "topics",
+						Fields: []FieldChange{{Field: "topics", From: "[go]", To: "[go api]"}},
+					}},
+					Duration: 2 * time.Second,
+				},
+				{
+					Handler: "webhooks",
+					Changes: []Change{
+						{Action: ChangeActionCreate, Item: "https://ci.example.com (gitea)"},
+						{Action: ChangeActionDelete, Item: "https://old.example.com (gitea)"},
+					},
+					Duration: time.Second,
+				},
+				{Handler: "labels", Duration: time.Second},
+			},
+		},
+		{
+			Repo: "org/web",
+			Handlers: []handlerResult{
+				{Handler: "topics", Err: errors.New("forbidden")},
+				{Handler: "webhooks", Skipped: true},
+			},
+		},
+		{Repo: "org/gone", Err: errors.New("repo not found")},
+		{Repo: "org/later", Skipped: true},
+	}
+}
+
+func TestNewReport(t *testing.T) {
+	report := newReport("push", true, time.Now(), testResults())
+
+	if report.Command != "push" || !report.DryRun {
+		t.Errorf("report = %s (dry run %v), want push (dry run true)", report.Command, report.DryRun)
+	}
+
+	want := []struct {
+		repo, handler, action, item, err string
+	}{
+		{"org/api", "topics", "updated", "topics", ""},
+		{"org/api", "webhooks", "created", "https://ci.example.com (gitea)", ""},
+		{"org/api", "webhooks", "deleted", "https://old.example.com (gitea)", ""},
+		{"org/api", "labels", "unchanged", "", ""},
+		{"org/web", "topics", "failed", "", "forbidden"},
+		{"org/web", "webhooks", "skipped", "", ""},
+		{"org/gone", "", "failed", "", "repo not found"},
+		{"org/later", "", "skipped", "", ""},
+	}
+	if len(report.Records) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(report.Records), len(want), report.Records)
+	}
+	for i, w := range want {
+		r := report.Records[i]
+		if r.Repo != w.repo || r.Handler != w.handler || r.Action != w.action || r.Item != w.item || r.Error != w.err {
+			t.Errorf("record %d = %+v, want %+v", i, r, w)
+		}
+	}
+
+	if got := report.Records[0]; len(got.Fields) != 1 || got.Duration != 2 {
+		t.Errorf("record 0 fields = %v, duration = %v, want one field and 2 seconds", got.Fields, got.Duration)
+	}
+}
+
+func TestNewReportWithoutResults(t *testing.T) {
+	report := newReport("plan", false, time.Now(), nil)
+	if report.Records == nil || len(report.Records) != 0 {
+		t.Errorf("records = %#v, want an empty list", report.Records)
+	}
+}
+
+func TestNewJUnitReport(t *testing.T) {
+	results := testResults()
+	suites := newJUnitReport(newReport("push", false, time.Now(), results), results)
+
+	if suites.Name != "gitea-config-wave push" {
+		t.Errorf("name = %q", suites.Name)
+	}
+	if suites.Tests != 7 || suites.Failures != 2 || suites.Skipped != 2 {
+		t.Errorf("tests/failures/skipped = %d/%d/%d, want 7/2/2", suites.Tests, suites.Failures, suites.Skipped)
+	}
+
+	tests := []struct {
+		suite                    string
+		tests, failures, skipped int
+		time                     string
+	}{
+		{"org/api", 3, 0, 0, "4.000"},
+		{"org/web", 2, 1, 1, "0.000"},
+		{"org/gone", 1, 1, 0, "0.000"},
+		{"org/later", 1, 0, 1, "0.000"},
+	}
+	if len(suites.Suites) != len(tests) {
+		t.Fatalf("got %d suites, want %d", len(suites.Suites), len(tests))
+	}
+	for i, tt := range tests {
+		s := suites.Suites[i]
+		if s.Name != tt.suite || s.Tests != tt.tests || s.Failures != tt.failures || s.Skipped != tt.skipped || s.Time != tt.time {
+			t.Errorf("suite %d = %s %d/%d/%d in %s, want %s %d/%d/%d in %s",
+				i, s.Name, s.Tests, s.Failures, s.Skipped, s.Time,
+				tt.suite, tt.tests, tt.failures, tt.skipped, tt.time)
+		}
+	}
+
+	api := suites.Suites[0].TestCases
+	if want := "updated topics\n  topics: [go] -> [go api]\n"; api[0].SystemOut != want {
+		t.Errorf("topics output = %q, want %q", api[0].SystemOut, want)
+	}
+	if !strings.Contains(api[1].SystemOut, "deleted https://old.example.com (gitea)") {
+		t.Errorf("webhooks output = %q, want the deleted hook", api[1].SystemOut)
+	}
+	if api[2].SystemOut != "unchanged\n" {
+		t.Errorf("labels output = %q, want unchanged", api[2].SystemOut)
+	}
+
+	gone := suites.Suites[2].TestCases[0]
+	if gone.Name != "push" || gone.Failure == nil || gone.Failure.Message != "repo not found" {
+		t.Errorf("failed repo test case = %+v", gone)
+	}
+}
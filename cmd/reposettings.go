@@ -1,22 +1,40 @@
 package cmd
 
+// The Go Gitea SDK does not know about several repository settings, such as
+// fast-forward-only merges and the projects mode, so this handler reads and
+// edits repositories with the REST API directly.
+
 import (
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"code.gitea.io/sdk/gitea"
 	"gopkg.in/yaml.v3"
 )
 
-type RepoSettingsHandler struct{}
+type RepoSettingsHandler struct {
+	Config *Config
+	DryRun bool
+}
 
 type RepoSettings struct {
+	Description                   *string                `yaml:"description,omitempty"`
+	Website                       *string                `yaml:"website,omitempty"`
+	Private                       *bool                  `yaml:"private,omitempty"`
+	Template                      *bool                  `yaml:"template,omitempty"`
+	Archived                      *bool                  `yaml:"archived,omitempty"`
 	DefaultBranch                 *string                `yaml:"default_branch,omitempty"`
 	HasIssues                     *bool                  `yaml:"has_issues,omitempty"`
+	InternalTracker               *gitea.InternalTracker `yaml:"internal_tracker,omitempty"`
 	ExternalTracker               *gitea.ExternalTracker `yaml:"external_tracker,omitempty"`
 	HasWiki                       *bool                  `yaml:"has_wiki,omitempty"`
+	ExternalWiki                  *gitea.ExternalWiki    `yaml:"external_wiki,omitempty"`
 	HasPullRequests               *bool                  `yaml:"has_pull_requests,omitempty"`
 	HasProjects                   *bool                  `yaml:"has_projects,omitempty"`
+	ProjectsMode                  *string                `yaml:"projects_mode,omitempty"`
 	HasReleases                   *bool                  `yaml:"has_releases,omitempty"`
 	HasPackages                   *bool                  `yaml:"has_packages,omitempty"`
 	HasActions                    *bool                  `yaml:"has_actions,omitempty"`
@@ -25,10 +43,84 @@ type RepoSettings struct {
 	AllowRebase                   *bool                  `yaml:"allow_rebase,omitempty"`
 	AllowRebaseExplicit           *bool                  `yaml:"allow_rebase_explicit,omitempty"`
 	AllowSquashMerge              *bool                  `yaml:"allow_squash_merge,omitempty"`
+	AllowFastForwardOnlyMerge     *bool                  `yaml:"allow_fast_forward_only_merge,omitempty"`
+	AllowRebaseUpdate             *bool                  `yaml:"allow_rebase_update,omitempty"`
+	AllowManualMerge              *bool                  `yaml:"allow_manual_merge,omitempty"`
+	AutodetectManualMerge         *bool                  `yaml:"autodetect_manual_merge,omitempty"`
 	DefaultDeleteBranchAfterMerge *bool                  `yaml:"default_delete_branch_after_merge,omitempty"`
 	DefaultMergeStyle             *string                `yaml:"default_merge_style,omitempty"`
 	DefaultAllowMaintainerEdit    *bool                  `yaml:"default_allow_maintainer_edit,omitempty"`
-	Topics                        []string               `yaml:"topics,omitempty"`
+	MirrorInterval                *string                `yaml:"mirror_interval,omitempty"`
+}
+
+// giteaRepository mirrors the settings in the Repository object of the Gitea
+// API. Gitea does not always return the manual merge settings.
+type giteaRepository struct {
+	Description                   string                 `json:"description"`
+	Website                       string                 `json:"website"`
+	Private                       bool                   `json:"private"`
+	Template                      bool                   `json:"template"`
+	Archived                      bool                   `json:"archived"`
+	Mirror                        bool                   `json:"mirror"`
+	DefaultBranch                 string                 `json:"default_branch"`
+	HasIssues                     bool                   `json:"has_issues"`
+	InternalTracker               *gitea.InternalTracker `json:"internal_tracker"`
+	ExternalTracker               *gitea.ExternalTracker `json:"external_tracker"`
+	HasWiki                       bool                   `json:"has_wiki"`
+	ExternalWiki                  *gitea.ExternalWiki    `json:"external_wiki"`
+	HasPullRequests               bool                   `json:"has_pull_requests"`
+	HasProjects                   bool                   `json:"has_projects"`
+	ProjectsMode                  string                 `json:"projects_mode"`
+	HasReleases                   bool                   `json:"has_releases"`
+	HasPackages                   bool                   `json:"has_packages"`
+	HasActions                    bool                   `json:"has_actions"`
+	IgnoreWhitespaceConflicts     bool                   `json:"ignore_whitespace_conflicts"`
+	AllowMerge                    bool                   `json:"allow_merge_commits"`
+	AllowRebase                   bool                   `json:"allow_rebase"`
+	AllowRebaseMerge              bool                   `json:"allow_rebase_explicit"`
+	AllowSquash                   bool                   `json:"allow_squash_merge"`
+	AllowFastForwardOnly          bool                   `json:"allow_fast_forward_only_merge"`
+	AllowRebaseUpdate             bool                   `json:"allow_rebase_update"`
+	AllowManualMerge              *bool                  `json:"allow_manual_merge"`
+	AutodetectManualMerge         *bool                  `json:"autodetect_manual_merge"`
+	DefaultDeleteBranchAfterMerge bool                   `json:"default_delete_branch_after_merge"`
+	DefaultMergeStyle             string                 `json:"default_merge_style"`
+	DefaultAllowMaintainerEdit    bool                   `json:"default_allow_maintainer_edit"`
+	MirrorInterval                string                 `json:"mirror_interval"`
+}
+
+// editRepoOption is the request body for editing a repository
+type editRepoOption struct {
+	Description                   *string                `json:"description,omitempty"`
+	Website                       *string                `json:"website,omitempty"`
+	Private                       *bool                  `json:"private,omitempty"`
+	Template                      *bool                  `json:"template,omitempty"`
+	Archived                      *bool                  `json:"archived,omitempty"`
+	DefaultBranch                 *string                `json:"default_branch,omitempty"`
+	HasIssues                     *bool                  `json:"has_issues,omitempty"`
+	InternalTracker               *gitea.InternalTracker `json:"internal_tracker,omitempty"`
+	ExternalTracker               *gitea.ExternalTracker `json:"external_tracker,omitempty"`
+	HasWiki                       *bool                  `json:"has_wiki,omitempty"`
+	ExternalWiki                  *gitea.ExternalWiki    `json:"external_wiki,omitempty"`
+	HasPullRequests               *bool                  `json:"has_pull_requests,omitempty"`
+	HasProjects                   *bool                  `json:"has_projects,omitempty"`
+	ProjectsMode                  *string                `json:"projects_mode,omitempty"`
+	HasReleases                   *bool                  `json:"has_releases,omitempty"`
+	HasPackages                   *bool                  `json:"has_packages,omitempty"`
+	HasActions                    *bool                  `json:"has_actions,omitempty"`
+	IgnoreWhitespaceConflicts     *bool                  `json:"ignore_whitespace_conflicts,omitempty"`
+	AllowMerge                    *bool                  `json:"allow_merge_commits,omitempty"`
+	AllowRebase                   *bool                  `json:"allow_rebase,omitempty"`
+	AllowRebaseMerge              *bool                  `json:"allow_rebase_explicit,omitempty"`
+	AllowSquash                   *bool                  `json:"allow_squash_merge,omitempty"`
+	AllowFastForwardOnly          *bool                  `json:"allow_fast_forward_only_merge,omitempty"`
+	AllowRebaseUpdate             *bool                  `json:"allow_rebase_update,omitempty"`
+	AllowManualMerge              *bool                  `json:"allow_manual_merge,omitempty"`
+	AutodetectManualMerge         *bool                  `json:"autodetect_manual_merge,omitempty"`
+	DefaultDeleteBranchAfterMerge *bool                  `json:"default_delete_branch_after_merge,omitempty"`
+	DefaultMergeStyle             *string                `json:"default_merge_style,omitempty"`
+	DefaultAllowMaintainerEdit    *bool                  `json:"default_allow_maintainer_edit,omitempty"`
+	MirrorInterval                *string                `json:"mirror_interval,omitempty"`
 }
 
 func (h *RepoSettingsHandler) Name() string {
@@ -40,80 +132,218 @@ func (h *RepoSettingsHandler) Path() string {
 }
 
 func (h *RepoSettingsHandler) Pull(client *gitea.Client, owner, repo string) (interface{}, error) {
-	repoSDK, _, err := client.GetRepo(owner, repo)
+	gr, err := h.getRepo(h.Config, owner, repo)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get repo %s/%s: %w", owner, repo, err)
+		return nil, err
+	}
+
+	return toRepoSettings(gr), nil
+}
+
+func (h *RepoSettingsHandler) Push(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error) {
+	changes, err := h.Plan(client, owner, repo, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyChanges(h.Name(), owner, repo, changes, h.DryRun)
+}
+
+// repoSettingsItem is the item of the change to the settings of a repository
+// in plans and audits; the repository is reported with every change
+const repoSettingsItem = "settings"
+
+func (h *RepoSettingsHandler) Plan(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error) {
+	rs, ok := data.(*RepoSettings)
+	if !ok {
+		return nil, fmt.Errorf("invalid data type for RepoSettingsHandler")
+	}
+
+	cfg := h.Config
+	gr, err := h.getRepo(cfg, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	live := toRepoSettings(gr)
+	if applicable := applicableRepoSettings(live, rs); applicable != rs {
+		logger.Warn("ignoring mirror_interval of a repository that is not a mirror", "repo", owner+"/"+repo)
+		rs = applicable
+	}
+
+	fields := repoSettingsFields(live, rs)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	return []Change{{
+		Action: ChangeActionUpdate,
+		Item:   repoSettingsItem,
+		Fields: fields,
+		apply: func() error {
+			path := fmt.Sprintf("/repos/%s/%s", owner, repo)
+			return giteaAPIRequest(cfg, http.MethodPatch, path, toEditRepoOption(rs), nil)
+		},
+	}}, nil
+}
+
+func (h *RepoSettingsHandler) Diff(live, desired interface{}) ([]Change, error) {
+	liveRS, ok := live.(*RepoSettings)
+	desiredRS, ok2 := desired.(*RepoSettings)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("invalid data type for RepoSettingsHandler")
+	}
+
+	fields := repoSettingsFields(liveRS, applicableRepoSettings(liveRS, desiredRS))
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return []Change{{Action: ChangeActionUpdate, Item: repoSettingsItem, Fields: fields}}, nil
+}
+
+// repoSettingsFields returns the settings that differ between the live and
+// the desired repository. Gitea does not return the manual merge settings of
+// every repository, so they are only compared when it does.
+func repoSettingsFields(live, desired *RepoSettings) []FieldChange {
+	compared := *desired
+	if live.AllowManualMerge == nil {
+		compared.AllowManualMerge = nil
 	}
+	if live.AutodetectManualMerge == nil {
+		compared.AutodetectManualMerge = nil
+	}
+	return diffFields(live, &compared)
+}
+
+// applicableRepoSettings returns the desired settings without the ones that
+// do not apply to the live repository. Gitea rejects a mirror interval for
+// repositories that are not mirrors.
+func applicableRepoSettings(live, desired *RepoSettings) *RepoSettings {
+	if desired.MirrorInterval == nil || live.MirrorInterval != nil {
+		return desired
+	}
+
+	applicable := *desired
+	applicable.MirrorInterval = nil
+	return &applicable
+}
 
-	return toRepoSettings(repoSDK), nil
+func (h *RepoSettingsHandler) getRepo(cfg *Config, owner, repo string) (*giteaRepository, error) {
+	var gr giteaRepository
+	path := fmt.Sprintf("/repos/%s/%s", owner, repo)
+	if err := giteaAPIRequest(cfg, http.MethodGet, path, nil, &gr); err != nil {
+		return nil, fmt.Errorf("failed to get repo %s/%s: %w", owner, repo, err)
+	}
+	return &gr, nil
 }
 
-func (h *RepoSettingsHandler) Push(client *gitea.Client, owner, repo string, data interface{}) error {
+// shared returns pulled settings without the ones that identify the source
+// repository or set its visibility. They are written commented out, so they
+// are not pushed to every repository unless they are uncommented.
+func (h *RepoSettingsHandler) shared(data interface{}) (interface{}, error) {
 	rs, ok := data.(*RepoSettings)
 	if !ok {
-		return fmt.Errorf("invalid data type for RepoSettingsHandler")
-	}
-
-	// Update repository settings
-	_, _, err := client.EditRepo(owner, repo, toEditRepoOption(rs))
-	return err
-}
-
-func toRepoSettings(gr *gitea.Repository) *RepoSettings {
-	defaultBranch := gr.DefaultBranch
-	hasIssues := gr.HasIssues
-	hasWiki := gr.HasWiki
-	hasPullRequests := gr.HasPullRequests
-	hasProjects := gr.HasProjects
-	hasReleases := gr.HasReleases
-	hasPackages := gr.HasPackages
-	hasActions := gr.HasActions
-	ignoreWhitespaceConflicts := gr.IgnoreWhitespaceConflicts
-	allowMergeCommits := gr.AllowMerge
-	allowRebase := gr.AllowRebase
-	allowRebaseExplicit := gr.AllowRebaseMerge
-	allowSquashMerge := gr.AllowSquash
-	defaultDeleteBranchAfterMerge := false
-	defaultMergeStyle := string(gr.DefaultMergeStyle)
-	defaultAllowMaintainerEdit := false
-
-	return &RepoSettings{
-		DefaultBranch:                 &defaultBranch,
-		HasIssues:                     &hasIssues,
+		return nil, fmt.Errorf("invalid data type for RepoSettingsHandler")
+	}
+
+	specific := &RepoSettings{
+		Description: rs.Description,
+		Website:     rs.Website,
+		Private:     rs.Private,
+		Template:    rs.Template,
+		Archived:    rs.Archived,
+	}
+	commented, err := yaml.Marshal(specific)
+	if err != nil {
+		return nil, err
+	}
+
+	shared := *rs
+	shared.Description, shared.Website = nil, nil
+	shared.Private, shared.Template, shared.Archived = nil, nil, nil
+
+	var node yaml.Node
+	if err := node.Encode(&shared); err != nil {
+		return nil, err
+	}
+	node.HeadComment = "Settings of the source repository, uncomment to push them to every repository:\n" +
+		strings.TrimSpace(string(commented))
+	return &node, nil
+}
+
+func toRepoSettings(gr *giteaRepository) *RepoSettings {
+	rs := &RepoSettings{
+		Description:                   ptr(gr.Description),
+		Website:                       ptr(gr.Website),
+		Private:                       ptr(gr.Private),
+		Template:                      ptr(gr.Template),
+		Archived:                      ptr(gr.Archived),
+		DefaultBranch:                 ptr(gr.DefaultBranch),
+		HasIssues:                     ptr(gr.HasIssues),
+		InternalTracker:               gr.InternalTracker,
 		ExternalTracker:               gr.ExternalTracker,
-		HasWiki:                       &hasWiki,
-		HasPullRequests:               &hasPullRequests,
-		HasProjects:                   &hasProjects,
-		HasReleases:                   &hasReleases,
-		HasPackages:                   &hasPackages,
-		HasActions:                    &hasActions,
-		IgnoreWhitespaceConflicts:     &ignoreWhitespaceConflicts,
-		AllowMergeCommits:             &allowMergeCommits,
-		AllowRebase:                   &allowRebase,
-		AllowRebaseExplicit:           &allowRebaseExplicit,
-		AllowSquashMerge:              &allowSquashMerge,
-		DefaultDeleteBranchAfterMerge: &defaultDeleteBranchAfterMerge,
-		DefaultMergeStyle:             &defaultMergeStyle,
-		DefaultAllowMaintainerEdit:    &defaultAllowMaintainerEdit,
-	}
-}
-
-func toEditRepoOption(rs *RepoSettings) gitea.EditRepoOption {
-	return gitea.EditRepoOption{
-		DefaultBranch:             rs.DefaultBranch,
-		HasIssues:                 rs.HasIssues,
-		ExternalTracker:           rs.ExternalTracker,
-		HasWiki:                   rs.HasWiki,
-		HasPullRequests:           rs.HasPullRequests,
-		HasProjects:               rs.HasProjects,
-		HasReleases:               rs.HasReleases,
-		HasPackages:               rs.HasPackages,
-		HasActions:                rs.HasActions,
-		IgnoreWhitespaceConflicts: rs.IgnoreWhitespaceConflicts,
-		AllowMerge:                rs.AllowMergeCommits,
-		AllowRebase:               rs.AllowRebase,
-		AllowRebaseMerge:          rs.AllowRebaseExplicit,
-		AllowSquash:               rs.AllowSquashMerge,
+		HasWiki:                       ptr(gr.HasWiki),
+		ExternalWiki:                  gr.ExternalWiki,
+		HasPullRequests:               ptr(gr.HasPullRequests),
+		HasProjects:                   ptr(gr.HasProjects),
+		HasReleases:                   ptr(gr.HasReleases),
+		HasPackages:                   ptr(gr.HasPackages),
+		HasActions:                    ptr(gr.HasActions),
+		IgnoreWhitespaceConflicts:     ptr(gr.IgnoreWhitespaceConflicts),
+		AllowMergeCommits:             ptr(gr.AllowMerge),
+		AllowRebase:                   ptr(gr.AllowRebase),
+		AllowRebaseExplicit:           ptr(gr.AllowRebaseMerge),
+		AllowSquashMerge:              ptr(gr.AllowSquash),
+		AllowFastForwardOnlyMerge:     ptr(gr.AllowFastForwardOnly),
+		AllowRebaseUpdate:             ptr(gr.AllowRebaseUpdate),
+		AllowManualMerge:              gr.AllowManualMerge,
+		AutodetectManualMerge:         gr.AutodetectManualMerge,
+		DefaultDeleteBranchAfterMerge: ptr(gr.DefaultDeleteBranchAfterMerge),
+		DefaultMergeStyle:             ptr(gr.DefaultMergeStyle),
+		DefaultAllowMaintainerEdit:    ptr(gr.DefaultAllowMaintainerEdit),
+	}
+	// only set by Gitea versions with projects modes
+	if gr.ProjectsMode != "" {
+		rs.ProjectsMode = ptr(gr.ProjectsMode)
+	}
+	if gr.Mirror {
+		rs.MirrorInterval = ptr(gr.MirrorInterval)
+	}
+	return rs
+}
+
+func toEditRepoOption(rs *RepoSettings) editRepoOption {
+	return editRepoOption{
+		Description:                   rs.Description,
+		Website:                       rs.Website,
+		Private:                       rs.Private,
+		Template:                      rs.Template,
+		Archived:                      rs.Archived,
+		DefaultBranch:                 rs.DefaultBranch,
+		HasIssues:                     rs.HasIssues,
+		InternalTracker:               rs.InternalTracker,
+		ExternalTracker:               rs.ExternalTracker,
+		HasWiki:                       rs.HasWiki,
+		ExternalWiki:                  rs.ExternalWiki,
+		HasPullRequests:               rs.HasPullRequests,
+		HasProjects:                   rs.HasProjects,
+		ProjectsMode:                  rs.ProjectsMode,
+		HasReleases:                   rs.HasReleases,
+		HasPackages:                   rs.HasPackages,
+		HasActions:                    rs.HasActions,
+		IgnoreWhitespaceConflicts:     rs.IgnoreWhitespaceConflicts,
+		AllowMerge:                    rs.AllowMergeCommits,
+		AllowRebase:                   rs.AllowRebase,
+		AllowRebaseMerge:              rs.AllowRebaseExplicit,
+		AllowSquash:                   rs.AllowSquashMerge,
+		AllowFastForwardOnly:          rs.AllowFastForwardOnlyMerge,
+		AllowRebaseUpdate:             rs.AllowRebaseUpdate,
+		AllowManualMerge:              rs.AllowManualMerge,
+		AutodetectManualMerge:         rs.AutodetectManualMerge,
+		DefaultDeleteBranchAfterMerge: rs.DefaultDeleteBranchAfterMerge,
+		DefaultMergeStyle:             rs.DefaultMergeStyle,
+		DefaultAllowMaintainerEdit:    rs.DefaultAllowMaintainerEdit,
+		MirrorInterval:                rs.MirrorInterval,
 	}
 }
 
@@ -121,6 +351,52 @@ func (h *RepoSettingsHandler) Enabled() bool {
 	return true
 }
 
+func (h *RepoSettingsHandler) render(data interface{}, vars *repoVars) (interface{}, error) {
+	return renderStrings(data, vars)
+}
+
+func (h *RepoSettingsHandler) newData() interface{} {
+	return &RepoSettings{}
+}
+
+func (h *RepoSettingsHandler) validateData(data interface{}) []fieldError {
+	rs := data.(*RepoSettings)
+
+	var errs []fieldError
+	if rs.DefaultMergeStyle != nil {
+		errs = append(errs, checkEnum(*rs.DefaultMergeStyle, supportedMergeStyles, "default_merge_style")...)
+	}
+	if rs.ExternalTracker != nil {
+		errs = append(errs, checkEnum(rs.ExternalTracker.ExternalTrackerStyle, supportedTrackerStyles, "external_tracker", "externaltrackerstyle")...)
+		errs = append(errs, checkURL(rs.ExternalTracker.ExternalTrackerURL, "external_tracker", "externaltrackerurl")...)
+	}
+	// Gitea only applies the tracker and wiki settings together with the
+	// has_issues and has_wiki switches
+	if rs.InternalTracker != nil {
+		if rs.ExternalTracker != nil {
+			errs = append(errs, fieldErr("internal_tracker and external_tracker cannot be used together", "internal_tracker"))
+		}
+		if !deref(rs.HasIssues) {
+			errs = append(errs, fieldErr("internal_tracker requires has_issues: true", "internal_tracker"))
+		}
+	}
+	if rs.ExternalWiki != nil {
+		if !deref(rs.HasWiki) {
+			errs = append(errs, fieldErr("external_wiki requires has_wiki: true", "external_wiki"))
+		}
+		errs = append(errs, checkURL(rs.ExternalWiki.ExternalWikiURL, "external_wiki", "externalwikiurl")...)
+	}
+	if rs.ProjectsMode != nil {
+		errs = append(errs, checkEnum(*rs.ProjectsMode, supportedProjectsModes, "projects_mode")...)
+	}
+	if rs.MirrorInterval != nil {
+		if _, err := time.ParseDuration(*rs.MirrorInterval); err != nil {
+			errs = append(errs, fieldErr(fmt.Sprintf("invalid duration %q, e.g. 8h0m0s", *rs.MirrorInterval), "mirror_interval"))
+		}
+	}
+	return errs
+}
+
 func (h *RepoSettingsHandler) Load(path string) (interface{}, error) {
 	return readRepoSettings(path)
 }
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"code.gitea.io/sdk/gitea"
+)
+
+// TestRepoSettingsPlanAndDiff makes sure plan and audit report the same
+// drift the same way
+func TestRepoSettingsPlanAndDiff(t *testing.T) {
+	live := giteaRepository{HasIssues: true, HasWiki: true}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimPrefix(r.URL.Path, "/api/v1") {
+		case "/version":
+			writeJSON(w, http.StatusOK, map[string]string{"version": "1.22.0"})
+		case "/repos/org/api":
+			writeJSON(w, http.StatusOK, live)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := gitea.NewClient(server.URL, gitea.SetToken("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &RepoSettingsHandler{Config: &Config{GiteaURL: server.URL, GiteaToken: "token"}}
+
+	// the mirror interval does not apply to a repository that is not a mirror
+	desired := &RepoSettings{HasIssues: ptr(false), HasWiki: ptr(true), MirrorInterval: ptr("8h0m0s")}
+	want := []Change{{
+		Action: ChangeActionUpdate,
+		Item:   repoSettingsItem,
+		Fields: []FieldChange{{Field: "has_issues", From: "true", To: "false"}},
+	}}
+
+	planned, err := h.Plan(client, "org", "api", desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range planned {
+		planned[i].apply = nil
+	}
+	if !reflect.DeepEqual(planned, want) {
+		t.Errorf("Plan() = %+v, want %+v", planned, want)
+	}
+
+	diffed, err := h.Diff(toRepoSettings(&live), desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(diffed, want) {
+		t.Errorf("Diff() = %+v, want %+v", diffed, want)
+	}
+}
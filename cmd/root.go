@@ -1,13 +1,17 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"sync"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -19,6 +23,7 @@ var Version = "dev"
 
 type colorHandler struct {
 	out    io.Writer
+	mu     *sync.Mutex
 	opts   *slog.HandlerOptions
 	attrs  []slog.Attr
 	groups []string
@@ -30,6 +35,7 @@ func newColorHandler(w io.Writer, opts *slog.HandlerOptions) *colorHandler {
 	}
 	return &colorHandler{
 		out:  w,
+		mu:   &sync.Mutex{},
 		opts: opts,
 	}
 }
@@ -63,7 +69,9 @@ func (h *colorHandler) Handle(ctx context.Context, r slog.Record) error {
 	color := getLevelColor(r.Level)
 	reset := "\033[0m"
 
-	fmt.Fprintf(h.out, "%s %s%5s%s %s",
+	// build the whole line first so concurrent workers don't interleave output
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s%5s%s %s",
 		timeStr,
 		color,
 		level,
@@ -71,22 +79,31 @@ func (h *colorHandler) Handle(ctx context.Context, r slog.Record) error {
 		r.Message,
 	)
 
+	for _, a := range h.attrs {
+		fmt.Fprintf(&buf, " %s=%v", a.Key, a.Value)
+	}
+
 	if r.NumAttrs() > 0 {
 		r.Attrs(func(a slog.Attr) bool {
 			if a.Key == slog.TimeKey {
 				return true
 			}
-			fmt.Fprintf(h.out, " %s=%v", a.Key, a.Value)
+			fmt.Fprintf(&buf, " %s=%v", a.Key, a.Value)
 			return true
 		})
 	}
-	fmt.Fprintln(h.out)
-	return nil
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.out.Write(buf.Bytes())
+	return err
 }
 
 func (h *colorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	return &colorHandler{
 		out:    h.out,
+		mu:     h.mu,
 		opts:   h.opts,
 		attrs:  append(h.attrs, attrs...),
 		groups: h.groups,
@@ -96,6 +113,7 @@ func (h *colorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 func (h *colorHandler) WithGroup(name string) slog.Handler {
 	return &colorHandler{
 		out:    h.out,
+		mu:     h.mu,
 		opts:   h.opts,
 		attrs:  h.attrs,
 		groups: append(h.groups, name),
@@ -123,12 +141,31 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// exitCodeError makes Execute exit with a specific code instead of 1
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		logger.Error("failed to execute command",
 			"error", err,
 			"cmd", os.Args[0],
 		)
+
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
@@ -157,35 +194,60 @@ func init() {
 type Config struct {
 	GiteaURL   string `yaml:"gitea_url" validate:"required,url"`
 	GiteaToken string `yaml:"gitea_token" validate:"required"`
-	Config     struct {
-		OutputDir string `yaml:"output_dir" validate:"omitempty,dirpath"`
-	} `yaml:"config"`
-	Pull struct {
-		RepoSettings      bool `yaml:"repo_settings"`
-		Topics            bool `yaml:"topics"`
-		BranchProtections bool `yaml:"branch_protections"`
-		TagProtections    bool `yaml:"tag_protections"`
-		Webhooks          bool `yaml:"webhooks"`
-		Templates         bool `yaml:"templates"`
-	} `yaml:"pull"`
-	Push struct {
-		RepoSettings      bool `yaml:"repo_settings"`
-		Topics            bool `yaml:"topics"`
-		BranchProtections bool `yaml:"branch_protections"`
-		TagProtections    bool `yaml:"tag_protections"`
-		Webhooks          bool `yaml:"webhooks"`
-		Templates         bool `yaml:"templates"`
-	} `yaml:"push"`
-	Targets struct {
-		Autodiscover       bool     `yaml:"autodiscover"`
-		Organization       string   `yaml:"organization"`
-		AutodiscoverFilter string   `yaml:"autodiscover_filter"`
-		Repos              []string `yaml:"repos"`
-		ExcludeRepos       []string `yaml:"exclude_repos"`
-	} `yaml:"targets"`
+	// GiteaTokenFile and GiteaTokenCommand read the token from a file or the
+	// output of a shell command instead
+	GiteaTokenFile    string `yaml:"gitea_token_file"`
+	GiteaTokenCommand string `yaml:"gitea_token_command"`
+
+	Config                          FilesConfig    `yaml:"config"`
+	Pull                            HandlerToggles `yaml:"pull"`
+	Push                            HandlerToggles `yaml:"push"`
+	Targets                         TargetsConfig  `yaml:"targets"`
 	DryRun                          bool           `yaml:"dry_run"`
+	Concurrency                     int            `yaml:"concurrency"`
+	ContinueOnError                 bool           `yaml:"continue_on_error"`
 	TopicsUpdateStrategy            UpdateStrategy `yaml:"topics_update_strategy"`
 	BranchProtectionsUpdateStrategy UpdateStrategy `yaml:"branch_protections_update_strategy"`
 	TagProtectionsUpdateStrategy    UpdateStrategy `yaml:"tag_protections_update_strategy"`
 	WebhooksUpdateStrategy          UpdateStrategy `yaml:"webhooks_update_strategy"`
+	Groups                          []TargetGroup  `yaml:"groups"`
+
+	// Variables are available to the placeholders of every repo, e.g. {{ .Vars.team }}
+	Variables map[string]string `yaml:"variables"`
+	// RepoVariables are the variables of single repos by full name; they take
+	// precedence over Variables
+	RepoVariables map[string]map[string]string `yaml:"repo_variables"`
+
+	// source is the YAML the config was parsed from; groups are parsed on top of it
+	source *yaml.Node
+}
+
+// FilesConfig is where the settings files are stored
+type FilesConfig struct {
+	OutputDir string `yaml:"output_dir" validate:"omitempty,dirpath"`
+}
+
+// HandlerToggles enables the handlers of the pull and push commands
+type HandlerToggles struct {
+	RepoSettings      bool `yaml:"repo_settings"`
+	Topics            bool `yaml:"topics"`
+	BranchProtections bool `yaml:"branch_protections"`
+	TagProtections    bool `yaml:"tag_protections"`
+	Webhooks          bool `yaml:"webhooks"`
+	Templates         bool `yaml:"templates"`
+}
+
+// TargetsConfig selects the repositories to push to
+type TargetsConfig struct {
+	Autodiscover       bool         `yaml:"autodiscover"`
+	Organization       string       `yaml:"organization"`
+	AutodiscoverFilter RepoPatterns `yaml:"autodiscover_filter"`
+	Organizations      []RepoSource `yaml:"organizations"`
+	Users              []RepoSource `yaml:"users"`
+	Instance           bool         `yaml:"instance"`
+	InstanceFilter     RepoPatterns `yaml:"instance_filter"`
+	InstanceExclude    RepoPatterns `yaml:"instance_exclude"`
+	Repos              []string     `yaml:"repos"`
+	ExcludeRepos       RepoPatterns `yaml:"exclude_repos"`
+	RepoSelector       `yaml:",inline"`
 }
@@ -0,0 +1,437 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaCmd prints the JSON Schema of the config file or of a settings file
+var schemaCmd = &cobra.Command{
+	Use:   "schema [name]",
+	Short: "Generate JSON Schemas for the config file and settings files",
+	Long: `Generates JSON Schema documents for the config file and every settings
+file, e.g. for YAML language servers in editors or for pre-commit checks.
+
+With a name, prints the schema of that file to stdout. With --output-dir,
+writes the schemas of all files to <name>.schema.json in that directory.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return fmt.Errorf("could not parse --output-dir flag: %w", err)
+		}
+
+		schemas := settingsSchemas()
+		names := make([]string, 0, len(schemas))
+		for name := range schemas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		if outputDir != "" {
+			if len(args) > 0 {
+				return fmt.Errorf("a name and --output-dir cannot be used together")
+			}
+			if err := os.MkdirAll(outputDir, 0755); err != nil {
+				return fmt.Errorf("failed to create output directory %q: %w", outputDir, err)
+			}
+			for _, name := range names {
+				path := filepath.Join(outputDir, name+".schema.json")
+				if err := writeSchema(path, schemas[name]); err != nil {
+					return err
+				}
+				logger.Info("wrote schema", "file", path)
+			}
+			return nil
+		}
+
+		if len(args) == 0 {
+			return fmt.Errorf("a name or --output-dir is required (names: %s)", strings.Join(names, ", "))
+		}
+		schema, ok := schemas[args[0]]
+		if !ok {
+			return fmt.Errorf("unknown schema %q (names: %s)", args[0], strings.Join(names, ", "))
+		}
+
+		b, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode schema: %w", err)
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(b))
+		return nil
+	},
+}
+
+// settingsSchemas returns the schemas of the config file and of every
+// settings file, keyed by file name without extension
+func settingsSchemas() map[string]map[string]interface{} {
+	schemas := map[string]map[string]interface{}{
+		strings.TrimSuffix(DefaultConfigFile, ".yaml"): newJSONSchema(
+			"gitea-config-wave config",
+			"Configuration of gitea-config-wave: connection, target repos and what to pull and push.",
+			reflect.TypeOf(Config{}),
+		),
+	}
+
+	cfg := &Config{}
+	for _, handler := range []ConfigHandler{
+		&RepoSettingsHandler{Config: cfg},
+		&TopicsHandler{Config: cfg},
+		&BranchProtectionsHandler{Config: cfg},
+		&TagProtectionsHandler{Config: cfg},
+		&WebhooksHandler{Config: cfg},
+		&TemplatesHandler{Config: cfg},
+	} {
+		vh, ok := handler.(validatedHandler)
+		if !ok {
+			continue
+		}
+		name := strings.TrimSuffix(handler.Path(), ".yaml")
+		schemas[name] = newJSONSchema(
+			"gitea-config-wave "+handler.Name(),
+			fmt.Sprintf("Settings file with the %s to push to the target repositories.", handler.Name()),
+			reflect.TypeOf(vh.newData()),
+		)
+	}
+	return schemas
+}
+
+func writeSchema(path string, schema map[string]interface{}) error {
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schema: %w", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write schema: %w", err)
+	}
+	return nil
+}
+
+func newJSONSchema(title, description string, t reflect.Type) map[string]interface{} {
+	schema := typeSchema(t)
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = title
+	schema["description"] = description
+	return schema
+}
+
+var (
+	repoPatternsType = reflect.TypeOf(RepoPatterns{})
+	targetGroupType  = reflect.TypeOf(TargetGroup{})
+	yamlNodeType     = reflect.TypeOf(yaml.Node{})
+)
+
+// typeSchema returns the JSON Schema of the YAML representation of t
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case repoPatternsType:
+		return map[string]interface{}{
+			"description": `A shell-style glob, a regular expression prefixed with "re:", or a list of them.`,
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			},
+		}
+	case targetGroupType:
+		// groups cannot be nested, so leave them out to not recurse
+		schema := structSchema(reflect.TypeOf(Config{}), "groups")
+		properties := schema["properties"].(map[string]interface{})
+		properties["name"] = map[string]interface{}{
+			"type":        "string",
+			"description": "Name of the group, used with --group.",
+		}
+		schema["required"] = []string{"name"}
+		return schema
+	case yamlNodeType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema returns the schema of a struct without the skipped keys. Like
+// the validate command, it does not allow unknown keys.
+func structSchema(t reflect.Type, skip ...string) map[string]interface{} {
+	properties := make(map[string]interface{})
+	descriptions := schemaDescriptions[t]
+	enums := schemaEnums[t]
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(opts, "inline") {
+			inline := structSchema(f.Type)
+			for k, v := range inline["properties"].(map[string]interface{}) {
+				properties[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		if slices.Contains(skip, name) {
+			continue
+		}
+
+		property := typeSchema(f.Type)
+		if d, ok := descriptions[name]; ok {
+			property["description"] = d
+		}
+		if values, ok := enums[name]; ok {
+			property["enum"] = values
+		}
+		properties[name] = property
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+var (
+	updateStrategies       = []string{string(UpdateStrategyReplace), string(UpdateStrategyMerge), string(UpdateStrategyAppend), string(UpdateStrategySync)}
+	topicsUpdateStrategies = []string{string(UpdateStrategyReplace), string(UpdateStrategyAppend), string(UpdateStrategySync)}
+)
+
+// schemaEnums are the allowed values of fields, by type and YAML key
+var schemaEnums = map[reflect.Type]map[string][]string{
+	reflect.TypeOf(Config{}): {
+		"topics_update_strategy":             topicsUpdateStrategies,
+		"branch_protections_update_strategy": updateStrategies,
+		"tag_protections_update_strategy":    updateStrategies,
+		"webhooks_update_strategy":           updateStrategies,
+	},
+	reflect.TypeOf(RepoSelector{}): {
+		"visibility": {"public", "private", "internal"},
+	},
+	reflect.TypeOf(RepoSettings{}): {
+		"default_merge_style": supportedMergeStyles,
+		"projects_mode":       supportedProjectsModes,
+	},
+	reflect.TypeOf(gitea.ExternalTracker{}): {
+		"externaltrackerstyle": supportedTrackerStyles,
+	},
+	reflect.TypeOf(Webhook{}): {
+		"type": supportedWebhookTypes,
+	},
+}
+
+// schemaDescriptions describe the fields of the config and settings files, by
+// type and YAML key
+var schemaDescriptions = map[reflect.Type]map[string]string{
+	reflect.TypeOf(Config{}): {
+		"gitea_url":                          "URL of the Gitea instance. Overridden by the GITEA_URL environment variable.",
+		"gitea_token":                        "Access token. Overridden by the GITEA_TOKEN environment variable.",
+		"gitea_token_file":                   "File to read the access token from.",
+		"gitea_token_command":                "Shell command that prints the access token.",
+		"config":                             "Where the settings files are stored.",
+		"pull":                               "Settings to pull from a repository.",
+		"push":                               "Settings to push to the target repositories.",
+		"targets":                            "Repositories to push to.",
+		"dry_run":                            "Only show what push would change.",
+		"concurrency":                        "Number of repositories to process in parallel.",
+		"continue_on_error":                  "Keep pushing to the remaining repositories when one fails.",
+		"topics_update_strategy":             "How topics are updated.",
+		"branch_protections_update_strategy": "How branch protections are updated.",
+		"tag_protections_update_strategy":    "How tag protections are updated.",
+		"webhooks_update_strategy":           "How webhooks are updated.",
+		"groups":                             "Named groups of target repositories with their own settings. A repository belongs to the first group it matches.",
+		"variables":                          "Variables for the placeholders in settings files, e.g. {{ .Vars.team }}.",
+		"repo_variables":                     "Variables of single repositories by full name.",
+	},
+	reflect.TypeOf(FilesConfig{}): {
+		"output_dir": "Directory of the settings files. Defaults to .gitea/defaults.",
+	},
+	reflect.TypeOf(HandlerToggles{}): {
+		"repo_settings":      "Repository settings (repo_settings.yaml).",
+		"topics":             "Topics (topics.yaml).",
+		"branch_protections": "Branch protection rules (branch_protections.yaml).",
+		"tag_protections":    "Tag protection rules (tag_protections.yaml).",
+		"webhooks":           "Webhooks (webhooks.yaml).",
+		"templates":          "Issue and pull request templates (templates.yaml).",
+	},
+	reflect.TypeOf(TargetsConfig{}): {
+		"autodiscover":        "Discover the repositories of organization, organizations, users and instance instead of only using repos.",
+		"organization":        "Organization to discover repositories in.",
+		"autodiscover_filter": `Patterns the names of repositories in organization must match. Use "*" for all repositories; without a filter none match.`,
+		"organizations":       "Organizations to discover repositories in, each with its own filters.",
+		"users":               "User namespaces to discover repositories in, each with its own filters.",
+		"instance":            "Discover every repository on the instance. Requires an admin token.",
+		"instance_filter":     "Patterns the full names (owner/repo) of repositories on the instance must match.",
+		"instance_exclude":    "Patterns of full names (owner/repo) of repositories on the instance to skip.",
+		"repos":               "Full names (owner/repo) of repositories to push to.",
+		"exclude_repos":       "Patterns of full names (owner/repo) of repositories to skip.",
+	},
+	reflect.TypeOf(RepoSource{}): {
+		"name":    "Name of the organization or user.",
+		"filter":  "Patterns the repository name must match.",
+		"exclude": "Patterns of repository names to skip.",
+	},
+	reflect.TypeOf(RepoSelector{}): {
+		"visibility": "Only select repositories with this visibility.",
+		"archived":   "Only select archived (true) or unarchived (false) repositories.",
+		"fork":       "Only select forks (true) or non-forks (false).",
+		"mirror":     "Only select mirrors (true) or non-mirrors (false).",
+		"template":   "Only select template (true) or non-template (false) repositories.",
+		"languages":  "Only select repositories whose primary language is one of these.",
+		"topics":     "Only select repositories with at least one of these topics.",
+	},
+	reflect.TypeOf(RepoSettings{}): {
+		"description":                       "Description of the repository.",
+		"website":                           "Website of the repository.",
+		"private":                           "Make the repository private.",
+		"template":                          "Make the repository a template.",
+		"archived":                          "Archive the repository.",
+		"default_branch":                    "Default branch of the repository.",
+		"has_issues":                        "Enable the issue tracker.",
+		"internal_tracker":                  "Settings of the built-in issue tracker. Requires has_issues.",
+		"external_tracker":                  "External issue tracker.",
+		"has_wiki":                          "Enable the wiki.",
+		"external_wiki":                     "External wiki instead of the built-in one. Requires has_wiki.",
+		"has_pull_requests":                 "Enable pull requests.",
+		"has_projects":                      "Enable projects.",
+		"projects_mode":                     "Which projects are shown: those of the repository, of its owner or all.",
+		"has_releases":                      "Enable releases.",
+		"has_packages":                      "Enable packages.",
+		"has_actions":                       "Enable actions.",
+		"ignore_whitespace_conflicts":       "Ignore whitespace when checking pull requests for conflicts.",
+		"allow_merge_commits":               "Allow merge commits.",
+		"allow_rebase":                      "Allow rebasing.",
+		"allow_rebase_explicit":             "Allow rebasing with explicit merge commits.",
+		"allow_squash_merge":                "Allow squash merging.",
+		"allow_fast_forward_only_merge":     "Allow fast-forward-only merging.",
+		"allow_rebase_update":               "Allow updating pull request branches by rebase.",
+		"allow_manual_merge":                "Allow marking pull requests as manually merged.",
+		"autodetect_manual_merge":           "Detect pull requests that were merged manually.",
+		"default_delete_branch_after_merge": "Delete the head branch after merging by default.",
+		"default_merge_style":               "Default merge style of pull requests.",
+		"default_allow_maintainer_edit":     "Allow maintainers to edit pull requests by default.",
+		"mirror_interval":                   "Sync interval of mirrors, e.g. 8h0m0s. Ignored for other repositories.",
+	},
+	reflect.TypeOf(gitea.InternalTracker{}): {
+		"enabletimetracker":                "Enable time tracking.",
+		"allowonlycontributorstotracktime": "Only let contributors track time.",
+		"enableissuedependencies":          "Enable dependencies between issues and pull requests.",
+	},
+	reflect.TypeOf(gitea.ExternalWiki{}): {
+		"externalwikiurl": "URL of the external wiki. Placeholders such as {{ .Repo }} point every repository to its own page.",
+	},
+	reflect.TypeOf(gitea.ExternalTracker{}): {
+		"externaltrackerurl":    "URL of the external issue tracker.",
+		"externaltrackerformat": "URL format of issues; {user}, {repo} and {index} are replaced.",
+		"externaltrackerstyle":  "Format of issue numbers.",
+	},
+	reflect.TypeOf(BranchProtection{}): {
+		"branch_name":                       "Branch the rule applies to.",
+		"rule_name":                         "Name or glob pattern of the rule.",
+		"enable_push":                       "Allow pushing to the branch.",
+		"enable_push_whitelist":             "Only allow whitelisted users and teams to push.",
+		"push_whitelist_usernames":          "Users allowed to push.",
+		"push_whitelist_teams":              "Teams allowed to push.",
+		"push_whitelist_deploy_keys":        "Allow deploy keys to push.",
+		"enable_merge_whitelist":            "Only allow whitelisted users and teams to merge.",
+		"merge_whitelist_usernames":         "Users allowed to merge.",
+		"merge_whitelist_teams":             "Teams allowed to merge.",
+		"enable_status_check":               "Require status checks to pass before merging.",
+		"status_check_contexts":             "Status checks that must pass.",
+		"required_approvals":                "Number of approvals required to merge.",
+		"enable_approvals_whitelist":        "Only count approvals of whitelisted users and teams.",
+		"approvals_whitelist_usernames":     "Users whose approvals count.",
+		"approvals_whitelist_teams":         "Teams whose approvals count.",
+		"block_on_rejected_reviews":         "Block merging when changes are requested.",
+		"block_on_official_review_requests": "Block merging while official review requests are open.",
+		"block_on_outdated_branch":          "Block merging when the head branch is behind the base branch.",
+		"dismiss_stale_approvals":           "Dismiss approvals when new commits are pushed.",
+		"require_signed_commits":            "Require signed commits.",
+		"protected_file_patterns":           "Semicolon-separated globs of files that cannot be changed.",
+		"unprotected_file_patterns":         "Semicolon-separated globs of files that can be pushed to directly.",
+	},
+	reflect.TypeOf(BranchProtectionConfig{}): {
+		"rules": "Branch protection rules.",
+	},
+	reflect.TypeOf(TagProtection{}): {
+		"name_pattern":        "Tag name or glob pattern the rule applies to.",
+		"whitelist_usernames": "Users allowed to create and delete matching tags.",
+		"whitelist_teams":     "Teams allowed to create and delete matching tags.",
+	},
+	reflect.TypeOf(TagProtectionConfig{}): {
+		"rules": "Tag protection rules.",
+	},
+	reflect.TypeOf(WebhookConfig{}): {
+		"hooks": "Webhooks of the repository.",
+	},
+	reflect.TypeOf(Webhook{}): {
+		"id":                   "Ignored. Files pulled by older versions contain the ID of the webhook in the source repository.",
+		"key":                  "Old target URL of the webhook. An existing webhook with this URL is updated when none matches config.url, e.g. to move webhooks to a new URL.",
+		"type":                 "Type of the webhook.",
+		"url":                  "Target URL of the webhook, if config.url is not set.",
+		"branch_filter":        "Glob of the branches that trigger the webhook.",
+		"config":               "Webhook config such as url and content_type. Values can be secret references (env:, file: or cmd:).",
+		"events":               "Events that trigger the webhook.",
+		"active":               "Whether the webhook is active.",
+		"authorization_header": "Authorization header sent with every request. Can be a secret reference (env:, file: or cmd:).",
+	},
+	reflect.TypeOf(TopicsConfig{}): {
+		"topics":        "Topics of the repository. Lowercase letters, numbers, dashes and dots, at most 35 characters each.",
+		"topics_remove": "Topics to remove from the repository with any update strategy.",
+	},
+	reflect.TypeOf(TemplateFile{}): {
+		"path":    "Path of the file in the repository.",
+		"content": "Content of the file.",
+	},
+	reflect.TypeOf(TemplatesConfig{}): {
+		"issue_templates": "Issue templates.",
+		"issue_configs":   "Issue template chooser configs.",
+		"pr_templates":    "Pull request templates.",
+	},
+}
+
+func init() {
+	schemaCmd.Flags().String("output-dir", "",
+		"Write the schemas of all files to this directory")
+	rootCmd.AddCommand(schemaCmd)
+}
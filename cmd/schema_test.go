@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// schemaAt returns the schema at a path of property names; "[]" steps into
+// the items of an array
+func schemaAt(t *testing.T, schema map[string]interface{}, path ...string) map[string]interface{} {
+	t.Helper()
+	for _, p := range path {
+		var next interface{}
+		if p == "[]" {
+			next = schema["items"]
+		} else if properties, ok := schema["properties"].(map[string]interface{}); ok {
+			next = properties[p]
+		}
+		s, ok := next.(map[string]interface{})
+		if !ok {
+			t.Fatalf("no schema at %s", strings.Join(path, "."))
+		}
+		schema = s
+	}
+	return schema
+}
+
+func TestSettingsSchemas(t *testing.T) {
+	schemas := settingsSchemas()
+
+	tests := []struct {
+		file string
+		path []string
+		key  string
+		want interface{}
+	}{
+		{"gitea-config-wave", nil, "$schema", jsonSchemaDraft},
+		{"gitea-config-wave", nil, "additionalProperties", false},
+		{"gitea-config-wave", []string{"concurrency"}, "type", "integer"},
+		{"gitea-config-wave", []string{"topics_update_strategy"}, "enum", topicsUpdateStrategies},
+		{"gitea-config-wave", []string{"webhooks_update_strategy"}, "enum", updateStrategies},
+		{"gitea-config-wave", []string{"groups", "[]", "name"}, "type", "string"},
+		{"gitea-config-wave", []string{"groups", "[]"}, "required", []string{"name"}},
+		{"gitea-config-wave", []string{"groups", "[]", "targets", "organizations", "[]", "name"}, "type", "string"},
+		{"repo_settings", []string{"has_issues"}, "type", "boolean"},
+		{"repo_settings", []string{"default_merge_style"}, "enum", supportedMergeStyles},
+		{"topics", []string{"topics"}, "type", "array"},
+		{"topics", []string{"topics", "[]"}, "type", "string"},
+		{"branch_protections", []string{"rules", "[]", "required_approvals"}, "type", "integer"},
+		{"webhooks", []string{"hooks", "[]", "config"}, "type", "object"},
+		{"webhooks", []string{"hooks", "[]", "type"}, "enum", supportedWebhookTypes},
+	}
+
+	for _, tt := range tests {
+		name := tt.file + ":" + strings.Join(append(append([]string{}, tt.path...), tt.key), ".")
+		t.Run(name, func(t *testing.T) {
+			schema, ok := schemas[tt.file]
+			if !ok {
+				t.Fatalf("no schema for %s", tt.file)
+			}
+			if got := schemaAt(t, schema, tt.path...)[tt.key]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s = %#v, want %#v", tt.key, got, tt.want)
+			}
+		})
+	}
+
+	// groups cannot be nested
+	if _, ok := schemaAt(t, schemas["gitea-config-wave"], "groups", "[]")["properties"].(map[string]interface{})["groups"]; ok {
+		t.Error("schema of groups contains groups")
+	}
+}
+
+// TestSchemaKeysExist makes sure descriptions and enums are not left behind
+// for fields that were renamed or removed
+func TestSchemaKeysExist(t *testing.T) {
+	check := func(typ reflect.Type, keys []string) {
+		fields := make(map[string]bool)
+		for i := 0; i < typ.NumField(); i++ {
+			name, _, _ := strings.Cut(typ.Field(i).Tag.Get("yaml"), ",")
+			if name == "" {
+				name = strings.ToLower(typ.Field(i).Name)
+			}
+			fields[name] = true
+		}
+		for _, key := range keys {
+			if !fields[key] && !(typ == targetGroupType && key == "name") {
+				t.Errorf("%s has no field %q", typ, key)
+			}
+		}
+	}
+
+	for typ, descriptions := range schemaDescriptions {
+		var keys []string
+		for key := range descriptions {
+			keys = append(keys, key)
+		}
+		check(typ, keys)
+	}
+	for typ, enums := range schemaEnums {
+		var keys []string
+		for key := range enums {
+			keys = append(keys, key)
+		}
+		check(typ, keys)
+	}
+}
+
+func TestSchemaDescriptions(t *testing.T) {
+	var check func(path string, schema map[string]interface{})
+	check = func(path string, schema map[string]interface{}) {
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			check(path+"[]", items)
+		}
+		if values, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+			check(path+".*", values)
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, p := range properties {
+			property := p.(map[string]interface{})
+			if d, _ := property["description"].(string); d == "" {
+				t.Errorf("%s.%s has no description", path, name)
+			}
+			check(path+"."+name, property)
+		}
+	}
+
+	for file, schema := range settingsSchemas() {
+		check(file, schema)
+	}
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Secret references point to a value outside of the settings files. They are
+// resolved when a value is sent to Gitea or compared with the one in Gitea.
+const (
+	SecretRefEnv  = "env:"  // env:HOOK_SECRET reads an environment variable
+	SecretRefFile = "file:" // file:/run/secrets/hook reads a file, without trailing newlines
+	SecretRefCmd  = "cmd:"  // cmd:pass show hook prints the secret on stdout
+)
+
+// secretConfigKeys are the keys of a webhook config that hold secrets
+var secretConfigKeys = []string{"secret"}
+
+// secretPlaceholder is written by pull instead of the value of a secret; it
+// has to be replaced by a reference before the settings are pushed
+func secretPlaceholder(name string) string {
+	return SecretRefEnv + "WEBHOOK_" + strings.ToUpper(name)
+}
+
+func isSecretRef(value string) bool {
+	return strings.HasPrefix(value, SecretRefEnv) ||
+		strings.HasPrefix(value, SecretRefFile) ||
+		strings.HasPrefix(value, SecretRefCmd)
+}
+
+// resolveSecret returns the value a secret reference points to. Values that
+// are not references are returned as is.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, SecretRefEnv):
+		name := strings.TrimPrefix(value, SecretRefEnv)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s of secret is not set", name)
+		}
+		return secret, nil
+
+	case strings.HasPrefix(value, SecretRefFile):
+		path := strings.TrimPrefix(value, SecretRefFile)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+
+	case strings.HasPrefix(value, SecretRefCmd):
+		command := strings.TrimPrefix(value, SecretRefCmd)
+		shell, flag := "sh", "-c"
+		if runtime.GOOS == "windows" {
+			shell, flag = "cmd", "/C"
+		}
+
+		var stderr bytes.Buffer
+		c := exec.Command(shell, flag, command)
+		c.Stderr = &stderr
+		out, err := c.Output()
+		if err != nil {
+			// the command may contain credentials, so it is not part of the error
+			return "", fmt.Errorf("secret command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+
+	default:
+		return value, nil
+	}
+}
@@ -1,10 +1,17 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"code.gitea.io/sdk/gitea"
@@ -28,6 +35,108 @@ func GiteaClient(cfg *Config) (*gitea.Client, error) {
 	return client, nil
 }
 
+// giteaAPIRequest calls a Gitea API endpoint that the Go SDK does not cover.
+// The body is sent as JSON and the response is decoded into out if it is not nil.
+func giteaAPIRequest(cfg *Config, method, path string, body, out interface{}) error {
+	_, err := giteaAPIResponse(cfg, method, path, body, out)
+	return err
+}
+
+// giteaAPIList gets a page of a list endpoint the Go SDK does not cover. Like
+// with the SDK, the response holds the number of the next page for listAll.
+func giteaAPIList(cfg *Config, path string, out interface{}) (*gitea.Response, error) {
+	return giteaAPIResponse(cfg, http.MethodGet, path, nil, out)
+}
+
+func giteaAPIResponse(cfg *Config, method, path string, body, out interface{}) (*gitea.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		reader = bytes.NewReader(jsonData)
+	}
+
+	endpoint := strings.TrimSuffix(cfg.GiteaURL, "/") + "/api/v1" + path
+	request, err := http.NewRequest(method, endpoint, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", fmt.Sprintf("token %s", cfg.GiteaToken))
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(response.Body)
+		return nil, fmt.Errorf("%s %s: status %d: %s", method, path, response.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	resp := &gitea.Response{Response: response, NextPage: nextPage(response.Header)}
+	if out == nil {
+		return resp, nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return resp, nil
+}
+
+// nextPage returns the page the "next" link of a paginated response points to,
+// or 0 on the last page
+func nextPage(header http.Header) int {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		target, rel, ok := strings.Cut(link, ";")
+		if !ok || strings.TrimSpace(rel) != `rel="next"` {
+			continue
+		}
+		u, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return 0
+		}
+		page, _ := strconv.Atoi(u.Query().Get("page"))
+		return page
+	}
+	return 0
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// deref returns the value p points to, or the zero value if p is nil
+func deref[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
+
+// listAll collects every page of a paginated list endpoint
+func listAll[T any](list func(opts gitea.ListOptions) ([]T, *gitea.Response, error)) ([]T, error) {
+	var all []T
+	opts := gitea.ListOptions{Page: 1, PageSize: DefaultPageSize}
+	for {
+		items, resp, err := list(opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+
+		if resp == nil || resp.NextPage <= opts.Page || len(items) == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
 func parseRepoString(input string) (string, string, error) {
 	parts := strings.SplitN(input, "/", 2)
 	if len(parts) != 2 {
@@ -36,6 +145,14 @@ func parseRepoString(input string) (string, string, error) {
 	return parts[0], parts[1], nil
 }
 
+// resolveOutputDir returns the directory holding the settings files
+func resolveOutputDir(cfg *Config) string {
+	if cfg.Config.OutputDir == "" {
+		return DefaultOutputDir
+	}
+	return cfg.Config.OutputDir
+}
+
 func WriteYAMLFile(filePath string, data interface{}) error {
 	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 		return fmt.Errorf("create output directory: %w", err)
@@ -63,28 +180,40 @@ func ReadYAMLFile(filePath string, out interface{}) error {
 	return yaml.Unmarshal(b, out)
 }
 
+// envVarPattern matches ${VAR} references in the config file
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
 func LoadConfig(filePath string) (*Config, error) {
 	if filePath == "" {
 		filePath = DefaultConfigFile
 	}
 
+	// a .env file is optional; variables set in the environment take precedence
+	_ = godotenv.Load()
+
 	var cfg Config
 	yamlFile, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	if err := yaml.Unmarshal(yamlFile, &cfg); err != nil {
+	var root yaml.Node
+	if err := yaml.Unmarshal(yamlFile, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	expandEnvVars(&root)
+	if err := root.Decode(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	cfg.source = &root
 
-	if err := godotenv.Load(); err == nil {
-		if token := os.Getenv("GITEA_TOKEN"); token != "" {
-			cfg.GiteaToken = token
-		}
-		if url := os.Getenv("GITEA_URL"); url != "" {
-			cfg.GiteaURL = url
-		}
+	if token := os.Getenv("GITEA_TOKEN"); token != "" {
+		cfg.GiteaToken = token
+	} else if err := cfg.resolveToken(); err != nil {
+		return nil, err
+	}
+	if url := os.Getenv("GITEA_URL"); url != "" {
+		cfg.GiteaURL = url
 	}
 
 	if cfg.GiteaToken == "" {
@@ -97,3 +226,59 @@ func LoadConfig(filePath string) (*Config, error) {
 
 	return &cfg, nil
 }
+
+// expandEnvVars replaces every ${VAR} in the values of a parsed config with
+// the value of the environment variable; unset variables expand to an empty
+// string. Values are expanded after parsing, so a variable can never change
+// the structure of the config. Other uses of $, e.g. in regular expressions,
+// are left alone.
+func expandEnvVars(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		expanded := envVarPattern.ReplaceAllStringFunc(node.Value, func(match string) string {
+			return os.Getenv(envVarPattern.FindStringSubmatch(match)[1])
+		})
+		if expanded == node.Value {
+			return
+		}
+		node.Value = expanded
+		// unquoted values get their type from the expanded value, e.g. a port
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			expandEnvVars(node.Content[i])
+		}
+	default:
+		for _, child := range node.Content {
+			expandEnvVars(child)
+		}
+	}
+}
+
+// resolveToken reads the token from gitea_token_file or gitea_token_command,
+// if one of them is set
+func (c *Config) resolveToken() error {
+	sources := 0
+	for _, s := range []string{c.GiteaToken, c.GiteaTokenFile, c.GiteaTokenCommand} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of gitea_token, gitea_token_file and gitea_token_command can be set")
+	}
+
+	var err error
+	switch {
+	case c.GiteaTokenFile != "":
+		c.GiteaToken, err = resolveSecret(SecretRefFile + c.GiteaTokenFile)
+	case c.GiteaTokenCommand != "":
+		c.GiteaToken, err = resolveSecret(SecretRefCmd + c.GiteaTokenCommand)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read Gitea token: %w", err)
+	}
+	return nil
+}
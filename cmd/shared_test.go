@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestExpandEnvVars(t *testing.T) {
+	t.Setenv("TEST_TOKEN", "abc123")
+	t.Setenv("TEST_CONCURRENCY", "8")
+	t.Setenv("TEST_INJECTION", "x\ndry_run: true")
+	t.Setenv("TEST_KEY", "dry_run")
+
+	tests := []struct {
+		name string
+		yaml string
+		want Config
+	}{
+		{
+			name: "quoted value",
+			yaml: `gitea_token: "${TEST_TOKEN}"`,
+			want: Config{GiteaToken: "abc123"},
+		},
+		{
+			name: "part of a value",
+			yaml: `gitea_url: https://${TEST_TOKEN}.example.com`,
+			want: Config{GiteaURL: "https://abc123.example.com"},
+		},
+		{
+			name: "unquoted value gets the type of the expanded value",
+			yaml: `concurrency: ${TEST_CONCURRENCY}`,
+			want: Config{Concurrency: 8},
+		},
+		{
+			name: "unset variable",
+			yaml: `gitea_token: "${TEST_UNSET_VARIABLE}"`,
+			want: Config{},
+		},
+		{
+			name: "other uses of $ are kept",
+			yaml: `gitea_token: "$TEST_TOKEN ^main$"`,
+			want: Config{GiteaToken: "$TEST_TOKEN ^main$"},
+		},
+		{
+			name: "value cannot inject keys",
+			yaml: `gitea_token: ${TEST_INJECTION}`,
+			want: Config{GiteaToken: "x\ndry_run: true"},
+		},
+		{
+			name: "keys are not expanded",
+			yaml: `${TEST_KEY}: true`,
+			want: Config{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var root yaml.Node
+			if err := yaml.Unmarshal([]byte(tt.yaml), &root); err != nil {
+				t.Fatal(err)
+			}
+			expandEnvVars(&root)
+
+			var got Config
+			if err := root.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.GiteaURL != tt.want.GiteaURL || got.GiteaToken != tt.want.GiteaToken ||
+				got.Concurrency != tt.want.Concurrency || got.DryRun != tt.want.DryRun {
+				t.Errorf("got url %q, token %q, concurrency %d, dry run %v; want url %q, token %q, concurrency %d, dry run %v",
+					got.GiteaURL, got.GiteaToken, got.Concurrency, got.DryRun,
+					tt.want.GiteaURL, tt.want.GiteaToken, tt.want.Concurrency, tt.want.DryRun)
+			}
+		})
+	}
+}
@@ -1,159 +1,300 @@
 package cmd
 
-// TODO: Not supported yet (Go Gitea SDK does not support Tag Protections)
-
-// import (
-// 	"fmt"
-// 	"os"
-
-// 	"gopkg.in/yaml.v3"
-// )
-
-// type TagProtectionsHandler struct{}
-
-// type TagProtection struct {
-// 	NamePattern        string `yaml:"name_pattern"`
-// 	WhitelistUsernames string `yaml:"whitelist_usernames"`
-// 	WhitelistTeams     string `yaml:"whitelist_teams"`
-// }
-
-// type TagProtectionConfig struct {
-// 	Rules []TagProtection `yaml:"rules"`
-// }
-
-// func (h *TagProtectionsHandler) Name() string {
-// 	return "tag protections"
-// }
-
-// func (h *TagProtectionsHandler) Path() string {
-// 	return DefaultTagProtectionsFile
-// }
-
-// func (h *TagProtectionsHandler) Pull(client *gitea.Client, owner, repo string) (interface{}, error) {
-// 	protections, _, err := client.ListTagProtection(owner, repo, gitea.ListRepoTagProtectionsOptions{})
-// 	if err != nil {
-// 		return nil, fmt.Errorf("failed to list tag protections for %s/%s: %w", owner, repo, err)
-// 	}
-
-// 	transformedProtections := make([]TagProtection, len(protections))
-// 	for i, tp := range protections {
-// 		transformedProtections[i] = TagProtection{
-// 			NamePattern:        tp.NamePattern,
-// 			WhitelistUsernames: tp.WhitelistUsernames,
-// 			WhitelistTeams:     tp.WhitelistTeams,
-// 		}
-// 	}
-
-// 	return TagProtectionConfig{Rules: transformedProtections}, nil
-// }
-
-// func (h *TagProtectionsHandler) Push(client *gitea.Client, owner, repo string, data interface{}) error {
-// 	tpConfig, ok := data.(TagProtectionConfig)
-// 	if !ok {
-// 		return fmt.Errorf("invalid data type for TagProtectionsHandler")
-// 	}
-
-// 	cfg, err := LoadConfig(cfgFile)
-// 	if err != nil {
-// 		return fmt.Errorf("failed to load config: %w", err)
-// 	}
-
-// 	strategy := cfg.TagProtectionsUpdateStrategy
-// 	if err := h.validateUpdateStrategy(strategy); err != nil {
-// 		return err
-// 	}
-
-// 	existing, err := h.getExistingProtectionsMap(client, owner, repo)
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	if strategy == UpdateStrategyAppend {
-// 		for _, tp := range tpConfig.Rules {
-// 			if _, ok := existing[tp.NamePattern]; ok {
-// 				continue
-// 			}
-
-// 			_, _, err := client.CreateTagProtection(owner, repo, gitea.CreateTagProtectionOption{
-// 				NamePattern:        tp.NamePattern,
-// 				WhitelistUsernames: tp.WhitelistUsernames,
-// 				WhitelistTeams:     tp.WhitelistTeams,
-// 			})
-// 			if err != nil {
-// 				return fmt.Errorf("failed to create protection: %w", err)
-// 			}
-// 		}
-// 		return nil
-// 	}
-
-// 	if strategy == UpdateStrategyReplace {
-// 		for _, tp := range existing {
-// 			_, err := client.DeleteTagProtection(owner, repo, tp.ID)
-// 			if err != nil {
-// 				return fmt.Errorf("failed to delete tag protection: %w", err)
-// 			}
-// 			delete(existing, tp.NamePattern)
-// 		}
-// 	}
-
-// 	for _, tp := range tpConfig.Rules {
-// 		_, _, err := client.CreateTagProtection(owner, repo, gitea.CreateTagProtectionOption{
-// 			NamePattern:        tp.NamePattern,
-// 			WhitelistUsernames: tp.WhitelistUsernames,
-// 			WhitelistTeams:     tp.WhitelistTeams,
-// 		})
-// 		if err != nil {
-// 			return fmt.Errorf("failed to create tag protection: %w", err)
-// 		}
-// 	}
-
-// 	return nil
-// }
-
-// func (h *TagProtectionsHandler) getExistingProtectionsMap(client *gitea.Client, owner, repo string) (map[string]*gitea.TagProtection, error) {
-// 	protections, _, err := client.ListTagProtections(owner, repo, gitea.ListRepoTagProtectionsOptions{})
-// 	if err != nil {
-// 		return nil, fmt.Errorf("failed to list tag protections: %w", err)
-// 	}
-
-// 	m := make(map[string]*gitea.TagProtection, len(protections))
-// 	for _, tp := range protections {
-// 		m[tp.NamePattern] = tp
-// 	}
-
-// 	return m, nil
-// }
-
-// func (h *TagProtectionsHandler) validateUpdateStrategy(strategy UpdateStrategy) error {
-// 	supported := map[UpdateStrategy]bool{
-// 		UpdateStrategyReplace: true,
-// 		UpdateStrategyMerge:   true,
-// 		UpdateStrategyAppend:  true,
-// 	}
-
-// 	if _, ok := supported[strategy]; !ok {
-// 		return fmt.Errorf("invalid tag_protections_update_strategy: %s (must be 'replace', 'merge', or 'append')", strategy)
-// 	}
-
-// 	return nil
-// }
-
-// func (h *TagProtectionsHandler) Enabled() bool {
-// 	return true
-// }
-
-// func readTagProtections(path string) (TagProtectionConfig, error) {
-// 	b, err := os.ReadFile(path)
-// 	if err != nil {
-// 		return TagProtectionConfig{}, err
-// 	}
-// 	var tpConfig TagProtectionConfig
-// 	if err := yaml.Unmarshal(b, &tpConfig); err != nil {
-// 		return TagProtectionConfig{}, err
-// 	}
-// 	return tpConfig, nil
-// }
-
-// func (h *TagProtectionsHandler) Load(path string) (interface{}, error) {
-// 	return readTagProtections(path)
-// }
+// The Go Gitea SDK does not support tag protections, so this handler talks to
+// the REST API directly.
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+
+	"code.gitea.io/sdk/gitea"
+	"gopkg.in/yaml.v3"
+)
+
+type TagProtectionsHandler struct {
+	Config *Config
+	DryRun bool
+}
+
+type TagProtection struct {
+	NamePattern        string   `yaml:"name_pattern"`
+	WhitelistUsernames []string `yaml:"whitelist_usernames"`
+	WhitelistTeams     []string `yaml:"whitelist_teams"`
+}
+
+type TagProtectionConfig struct {
+	Rules []TagProtection `yaml:"rules"`
+}
+
+// giteaTagProtection mirrors the TagProtection object of the Gitea API
+type giteaTagProtection struct {
+	ID                 int64    `json:"id"`
+	NamePattern        string   `json:"name_pattern"`
+	WhitelistUsernames []string `json:"whitelist_usernames"`
+	WhitelistTeams     []string `json:"whitelist_teams"`
+}
+
+// tagProtectionOption is the request body for creating and editing tag protections
+type tagProtectionOption struct {
+	NamePattern        string   `json:"name_pattern"`
+	WhitelistUsernames []string `json:"whitelist_usernames"`
+	WhitelistTeams     []string `json:"whitelist_teams"`
+}
+
+func (h *TagProtectionsHandler) Name() string {
+	return "tag protections"
+}
+
+func (h *TagProtectionsHandler) Path() string {
+	return DefaultTagProtectionsFile
+}
+
+func (h *TagProtectionsHandler) Pull(client *gitea.Client, owner, repo string) (interface{}, error) {
+	cfg := h.Config
+
+	protections, err := h.listTagProtections(cfg, owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tag protections for %s/%s: %w", owner, repo, err)
+	}
+
+	transformedProtections := make([]TagProtection, len(protections))
+	for i, tp := range protections {
+		transformedProtections[i] = toTagProtection(tp)
+	}
+
+	return TagProtectionConfig{Rules: transformedProtections}, nil
+}
+
+func (h *TagProtectionsHandler) Push(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error) {
+	changes, err := h.Plan(client, owner, repo, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyChanges(h.Name(), owner, repo, changes, h.DryRun)
+}
+
+func (h *TagProtectionsHandler) Plan(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error) {
+	tpConfig, ok := data.(TagProtectionConfig)
+	if !ok {
+		return nil, fmt.Errorf("invalid data type for TagProtectionsHandler")
+	}
+
+	cfg := h.Config
+
+	strategy := cfg.TagProtectionsUpdateStrategy
+	if err := h.validateUpdateStrategy(strategy); err != nil {
+		return nil, err
+	}
+
+	existing, err := h.getExistingProtectionsMap(cfg, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	if strategy == UpdateStrategyAppend {
+		for _, tp := range tpConfig.Rules {
+			if _, ok := existing[tp.NamePattern]; ok {
+				continue
+			}
+			changes = append(changes, h.createChange(cfg, owner, repo, tp))
+		}
+
+		return changes, nil
+	}
+
+	if strategy == UpdateStrategyReplace {
+		patterns := make([]string, 0, len(existing))
+		for pattern := range existing {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+
+		for _, pattern := range patterns {
+			changes = append(changes, h.deleteChange(cfg, owner, repo, existing[pattern]))
+			delete(existing, pattern)
+		}
+	}
+
+	desired := make(map[string]bool, len(tpConfig.Rules))
+	for _, tp := range tpConfig.Rules {
+		desired[tp.NamePattern] = true
+		if current, ok := existing[tp.NamePattern]; ok {
+			fields := diffFields(toTagProtection(current), tp)
+			if len(fields) == 0 {
+				continue
+			}
+
+			changes = append(changes, Change{
+				Action: ChangeActionUpdate,
+				Item:   tp.NamePattern,
+				Fields: fields,
+				apply: func() error {
+					path := fmt.Sprintf("/repos/%s/%s/tag_protections/%d", owner, repo, current.ID)
+					if err := giteaAPIRequest(cfg, http.MethodPatch, path, toTagProtectionOption(tp), nil); err != nil {
+						return fmt.Errorf("failed to update tag protection: %w", err)
+					}
+					return nil
+				},
+			})
+			continue
+		}
+
+		changes = append(changes, h.createChange(cfg, owner, repo, tp))
+	}
+
+	if strategy == UpdateStrategySync {
+		var patterns []string
+		for pattern := range existing {
+			if !desired[pattern] {
+				patterns = append(patterns, pattern)
+			}
+		}
+		sort.Strings(patterns)
+
+		for _, pattern := range patterns {
+			changes = append(changes, h.deleteChange(cfg, owner, repo, existing[pattern]))
+		}
+		changes = syncOrder(changes)
+	}
+
+	return changes, nil
+}
+
+func (h *TagProtectionsHandler) Diff(live, desired interface{}) ([]Change, error) {
+	liveTP, ok := live.(TagProtectionConfig)
+	desiredTP, ok2 := desired.(TagProtectionConfig)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("invalid data type for TagProtectionsHandler")
+	}
+
+	strategy := h.Config.TagProtectionsUpdateStrategy
+	if err := h.validateUpdateStrategy(strategy); err != nil {
+		return nil, err
+	}
+
+	changes := diffItems(liveTP.Rules, desiredTP.Rules, func(tp TagProtection) string {
+		return tp.NamePattern
+	})
+	return strategyChanges(changes, strategy), nil
+}
+
+func (h *TagProtectionsHandler) createChange(cfg *Config, owner, repo string, tp TagProtection) Change {
+	return Change{
+		Action: ChangeActionCreate,
+		Item:   tp.NamePattern,
+		apply: func() error {
+			path := fmt.Sprintf("/repos/%s/%s/tag_protections", owner, repo)
+			if err := giteaAPIRequest(cfg, http.MethodPost, path, toTagProtectionOption(tp), nil); err != nil {
+				return fmt.Errorf("failed to create tag protection: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+func (h *TagProtectionsHandler) deleteChange(cfg *Config, owner, repo string, tp *giteaTagProtection) Change {
+	return Change{
+		Action: ChangeActionDelete,
+		Item:   tp.NamePattern,
+		apply: func() error {
+			path := fmt.Sprintf("/repos/%s/%s/tag_protections/%d", owner, repo, tp.ID)
+			if err := giteaAPIRequest(cfg, http.MethodDelete, path, nil, nil); err != nil {
+				return fmt.Errorf("failed to delete tag protection: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+func toTagProtection(tp *giteaTagProtection) TagProtection {
+	return TagProtection{
+		NamePattern:        tp.NamePattern,
+		WhitelistUsernames: tp.WhitelistUsernames,
+		WhitelistTeams:     tp.WhitelistTeams,
+	}
+}
+
+func toTagProtectionOption(tp TagProtection) tagProtectionOption {
+	return tagProtectionOption{
+		NamePattern:        tp.NamePattern,
+		WhitelistUsernames: tp.WhitelistUsernames,
+		WhitelistTeams:     tp.WhitelistTeams,
+	}
+}
+
+func (h *TagProtectionsHandler) listTagProtections(cfg *Config, owner, repo string) ([]*giteaTagProtection, error) {
+	var protections []*giteaTagProtection
+	path := fmt.Sprintf("/repos/%s/%s/tag_protections", owner, repo)
+	if err := giteaAPIRequest(cfg, http.MethodGet, path, nil, &protections); err != nil {
+		return nil, err
+	}
+	return protections, nil
+}
+
+func (h *TagProtectionsHandler) getExistingProtectionsMap(cfg *Config, owner, repo string) (map[string]*giteaTagProtection, error) {
+	protections, err := h.listTagProtections(cfg, owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tag protections: %w", err)
+	}
+
+	m := make(map[string]*giteaTagProtection, len(protections))
+	for _, tp := range protections {
+		m[tp.NamePattern] = tp
+	}
+
+	return m, nil
+}
+
+func (h *TagProtectionsHandler) validateUpdateStrategy(strategy UpdateStrategy) error {
+	supported := map[UpdateStrategy]bool{
+		UpdateStrategyReplace: true,
+		UpdateStrategyMerge:   true,
+		UpdateStrategyAppend:  true,
+		UpdateStrategySync:    true,
+	}
+
+	if _, ok := supported[strategy]; !ok {
+		return fmt.Errorf("invalid tag_protections_update_strategy: %s (must be 'replace', 'merge', 'append' or 'sync')", strategy)
+	}
+
+	return nil
+}
+
+func (h *TagProtectionsHandler) Enabled() bool {
+	return true
+}
+
+func readTagProtections(path string) (TagProtectionConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return TagProtectionConfig{}, err
+	}
+	var tpConfig TagProtectionConfig
+	if err := yaml.Unmarshal(b, &tpConfig); err != nil {
+		return TagProtectionConfig{}, err
+	}
+	return tpConfig, nil
+}
+
+func (h *TagProtectionsHandler) newData() interface{} {
+	return &TagProtectionConfig{}
+}
+
+func (h *TagProtectionsHandler) validateData(data interface{}) []fieldError {
+	var errs []fieldError
+	for i, tp := range data.(*TagProtectionConfig).Rules {
+		if tp.NamePattern == "" {
+			errs = append(errs, fieldErr("name_pattern is required", "rules", strconv.Itoa(i)))
+		}
+	}
+	return errs
+}
+
+func (h *TagProtectionsHandler) Load(path string) (interface{}, error) {
+	return readTagProtections(path)
+}
@@ -8,6 +8,140 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// RepoSource is an organization or user whose repos are autodiscovered
+type RepoSource struct {
+	Name    string       `yaml:"name"`
+	Filter  RepoPatterns `yaml:"filter"`
+	Exclude RepoPatterns `yaml:"exclude"`
+}
+
+// RepoSelector narrows autodiscovered repos down by their attributes. Unset
+// fields match every repo.
+type RepoSelector struct {
+	Visibility string   `yaml:"visibility"` // public, private or internal
+	Archived   *bool    `yaml:"archived"`
+	Fork       *bool    `yaml:"fork"`
+	Mirror     *bool    `yaml:"mirror"`
+	Template   *bool    `yaml:"template"`
+	Languages  []string `yaml:"languages"` // primary language is one of these
+	Topics     []string `yaml:"topics"`    // repo has at least one of these topics
+}
+
+func (s RepoSelector) validate() error {
+	switch s.Visibility {
+	case "", "public", "private", "internal":
+		return nil
+	default:
+		return fmt.Errorf("invalid visibility %q (must be 'public', 'private' or 'internal')", s.Visibility)
+	}
+}
+
+// exclusionReason returns why a repo does not match the selector, or an empty
+// string if it does. Languages and topics are not part of the repo listing and
+// are only looked up when the selector asks for them.
+func (s RepoSelector) exclusionReason(client *gitea.Client, r *gitea.Repository) (string, error) {
+	visibility := "public"
+	if r.Internal {
+		visibility = "internal"
+	} else if r.Private {
+		visibility = "private"
+	}
+	if s.Visibility != "" && s.Visibility != visibility {
+		return fmt.Sprintf("visibility is %s", visibility), nil
+	}
+
+	flags := []struct {
+		name   string
+		want   *bool
+		actual bool
+	}{
+		{"archived", s.Archived, r.Archived},
+		{"fork", s.Fork, r.Fork},
+		{"mirror", s.Mirror, r.Mirror},
+		{"template", s.Template, r.Template},
+	}
+	for _, f := range flags {
+		if f.want == nil || *f.want == f.actual {
+			continue
+		}
+		if f.actual {
+			return fmt.Sprintf("repo is a %s", f.name), nil
+		}
+		return fmt.Sprintf("repo is not a %s", f.name), nil
+	}
+
+	owner, name, err := parseRepoString(r.FullName)
+	if err != nil {
+		return "", err
+	}
+
+	if len(s.Languages) > 0 {
+		languages, _, err := client.GetRepoLanguages(owner, name)
+		if err != nil {
+			return "", fmt.Errorf("failed to get languages of %s: %w", r.FullName, err)
+		}
+		primary := primaryLanguage(languages)
+		if !containsFold(s.Languages, primary) {
+			return fmt.Sprintf("primary language is %q", primary), nil
+		}
+	}
+
+	if len(s.Topics) > 0 {
+		topics, err := listRepoTopics(client, owner, name)
+		if err != nil {
+			return "", fmt.Errorf("failed to get topics of %s: %w", r.FullName, err)
+		}
+		found := false
+		for _, topic := range topics {
+			if containsFold(s.Topics, topic) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("none of the topics %v", s.Topics), nil
+		}
+	}
+
+	return "", nil
+}
+
+// primaryLanguage returns the language with the most bytes of code
+func primaryLanguage(languages map[string]int64) string {
+	var primary string
+	var size int64
+	for language, bytes := range languages {
+		if bytes > size || (bytes == size && language < primary) {
+			primary, size = language, bytes
+		}
+	}
+	return primary
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+type repoSourceKind string
+
+const (
+	repoSourceOrganization repoSourceKind = "organization"
+	repoSourceUser         repoSourceKind = "user"
+	repoSourceInstance     repoSourceKind = "instance"
+)
+
+// repoSource is a resolved place to discover repos from. Filters of the
+// instance source match full names (owner/repo), all others match repo names.
+type repoSource struct {
+	kind repoSourceKind
+	RepoSource
+}
+
 // getAllTargetRepos merges CLI arguments, autodiscovered repos, and configured targets
 func getAllTargetRepos(
 	cmd *cobra.Command,
@@ -21,14 +155,22 @@ func getAllTargetRepos(
 
 	var discovered []string
 	if cfg.Targets.Autodiscover {
-		if cfg.Targets.Organization == "" {
-			return nil, fmt.Errorf("autodiscover is enabled but organization is not set in config")
+		sources := discoverySources(cfg)
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("autodiscover is enabled but no organization, users or instance are set in config")
 		}
-		filter := cfg.Targets.AutodiscoverFilter
-		var err error
-		discovered, err = autodiscoverRepos(client, cfg.Targets.Organization, filter)
-		if err != nil {
-			return nil, fmt.Errorf("failed to autodiscover repos: %w", err)
+
+		selector := cfg.Targets.RepoSelector
+		if err := selector.validate(); err != nil {
+			return nil, err
+		}
+
+		for _, source := range sources {
+			repos, err := autodiscoverRepos(client, source, selector)
+			if err != nil {
+				return nil, fmt.Errorf("failed to autodiscover repos of %s %q: %w", source.kind, source.Name, err)
+			}
+			discovered = append(discovered, repos...)
 		}
 	}
 
@@ -37,12 +179,50 @@ func getAllTargetRepos(
 	finalList = deduplicate(finalList)
 
 	if len(cfg.Targets.ExcludeRepos) > 0 {
-		finalList = excludeRepos(finalList, cfg.Targets.ExcludeRepos)
+		before := len(finalList)
+		var err error
+		finalList, err = excludeRepos(finalList, cfg.Targets.ExcludeRepos)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude_repos: %w", err)
+		}
+		logger.Info("excluded repositories", "excluded", before-len(finalList), "remaining", len(finalList))
 	}
 
 	return finalList, nil
 }
 
+// discoverySources returns every autodiscovery source set in the config,
+// including the single organization of older configs
+func discoverySources(cfg *Config) []repoSource {
+	var sources []repoSource
+	if cfg.Targets.Organization != "" {
+		sources = append(sources, repoSource{
+			kind: repoSourceOrganization,
+			RepoSource: RepoSource{
+				Name:   cfg.Targets.Organization,
+				Filter: cfg.Targets.AutodiscoverFilter,
+			},
+		})
+	}
+	for _, org := range cfg.Targets.Organizations {
+		sources = append(sources, repoSource{kind: repoSourceOrganization, RepoSource: org})
+	}
+	for _, user := range cfg.Targets.Users {
+		sources = append(sources, repoSource{kind: repoSourceUser, RepoSource: user})
+	}
+	if cfg.Targets.Instance {
+		sources = append(sources, repoSource{
+			kind: repoSourceInstance,
+			RepoSource: RepoSource{
+				Name:    "*",
+				Filter:  cfg.Targets.InstanceFilter,
+				Exclude: cfg.Targets.InstanceExclude,
+			},
+		})
+	}
+	return sources
+}
+
 func deduplicate(input []string) []string {
 	seen := make(map[string]bool)
 	var output []string
@@ -56,32 +236,94 @@ func deduplicate(input []string) []string {
 	return output
 }
 
-func excludeRepos(initialList, excludeList []string) []string {
-	excludeMap := map[string]bool{}
-	for _, e := range excludeList {
-		excludeMap[strings.ToLower(e)] = true
+// excludeRepos drops every repo whose full name (owner/repo) matches one of
+// the exclude patterns
+func excludeRepos(initialList []string, excludeList RepoPatterns) ([]string, error) {
+	matcher, err := newRepoMatcher(excludeList)
+	if err != nil {
+		return nil, err
 	}
 
 	var final []string
 	for _, repo := range initialList {
-		if !excludeMap[strings.ToLower(repo)] {
-			final = append(final, repo)
+		if matcher.Match(repo) {
+			logger.Debug("excluding repository", "repo", repo)
+			continue
 		}
+		final = append(final, repo)
 	}
-	return final
+	return final, nil
+}
+
+// listSourceRepos lists every repo of a source. The instance source uses the
+// repo search, which covers all repos on the instance for admin tokens.
+func listSourceRepos(client *gitea.Client, source repoSource) ([]*gitea.Repository, error) {
+	return listAll(func(opts gitea.ListOptions) ([]*gitea.Repository, *gitea.Response, error) {
+		switch source.kind {
+		case repoSourceUser:
+			return client.ListUserRepos(source.Name, gitea.ListReposOptions{ListOptions: opts})
+		case repoSourceInstance:
+			return client.SearchRepos(gitea.SearchRepoOptions{ListOptions: opts})
+		default:
+			return client.ListOrgRepos(source.Name, gitea.ListOrgReposOptions{ListOptions: opts})
+		}
+	})
 }
 
-func autodiscoverRepos(client *gitea.Client, org string, filter string) ([]string, error) {
-	repos, _, err := client.ListOrgRepos(org, gitea.ListOrgReposOptions{})
+// autodiscoverRepos lists the repos of a source that match its filter, none
+// of its exclude patterns and the attribute selector. An empty filter matches
+// no repo; "*" matches every repo.
+func autodiscoverRepos(client *gitea.Client, source repoSource, selector RepoSelector) ([]string, error) {
+	filter, err := newRepoMatcher(source.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	if filter.Empty() {
+		logger.Warn("autodiscovery filter is empty, so no repositories match; use \"*\" to match all of them",
+			string(source.kind), source.Name)
+		return nil, nil
+	}
+	exclude, err := newRepoMatcher(source.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude: %w", err)
+	}
+
+	repos, err := listSourceRepos(client, source)
 	if err != nil {
 		return nil, err
 	}
 
 	var results []string
 	for _, r := range repos {
-		if filter == "*" {
-			results = append(results, fmt.Sprintf("%s/%s", org, r.Name))
+		name := r.Name
+		if source.kind == repoSourceInstance {
+			name = r.FullName
+		}
+
+		if !filter.Match(name) {
+			continue
 		}
+		if exclude.Match(name) {
+			logger.Debug("excluding repository", "repo", r.FullName, string(source.kind), source.Name)
+			continue
+		}
+
+		reason, err := selector.exclusionReason(client, r)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			logger.Info("excluding repository", "repo", r.FullName, "reason", reason)
+			continue
+		}
+		results = append(results, r.FullName)
 	}
+
+	logger.Info("autodiscovered repositories",
+		string(source.kind), source.Name,
+		"discovered", len(repos),
+		"matched", len(results),
+		"filtered_out", len(repos)-len(results),
+	)
 	return results, nil
 }
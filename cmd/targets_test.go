@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.gitea.io/sdk/gitea"
+)
+
+func TestExclusionReason(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/version":
+			writeJSON(w, http.StatusOK, map[string]string{"version": "1.22.0"})
+		case "/api/v1/repos/MyOrg/api/languages":
+			writeJSON(w, http.StatusOK, map[string]int64{"Go": 5000, "Shell": 200})
+		case "/api/v1/repos/MyOrg/api/topics":
+			writeJSON(w, http.StatusOK, map[string][]string{"topics": {"backend", "payments"}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := gitea.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := func(modify func(r *gitea.Repository)) *gitea.Repository {
+		r := &gitea.Repository{Name: "api", FullName: "MyOrg/api"}
+		if modify != nil {
+			modify(r)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		selector RepoSelector
+		repo     *gitea.Repository
+		want     string
+	}{
+		{"empty selector", RepoSelector{}, repo(nil), ""},
+		{"visibility matches", RepoSelector{Visibility: "private"}, repo(func(r *gitea.Repository) { r.Private = true }), ""},
+		{"visibility differs", RepoSelector{Visibility: "public"}, repo(func(r *gitea.Repository) { r.Private = true }), "visibility is private"},
+		{"internal", RepoSelector{Visibility: "private"}, repo(func(r *gitea.Repository) { r.Private, r.Internal = true, true }), "visibility is internal"},
+		{"mirrors excluded", RepoSelector{Mirror: ptr(false)}, repo(func(r *gitea.Repository) { r.Mirror = true }), "repo is a mirror"},
+		{"fork required", RepoSelector{Fork: ptr(true)}, repo(nil), "repo is not a fork"},
+		{"archived matches", RepoSelector{Archived: ptr(false)}, repo(nil), ""},
+		{"primary language matches", RepoSelector{Languages: []string{"go"}}, repo(nil), ""},
+		{"primary language differs", RepoSelector{Languages: []string{"Shell"}}, repo(nil), `primary language is "Go"`},
+		{"one of the topics", RepoSelector{Topics: []string{"frontend", "Backend"}}, repo(nil), ""},
+		{"none of the topics", RepoSelector{Topics: []string{"frontend"}}, repo(nil), "none of the topics [frontend]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.selector.exclusionReason(client, tt.repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("exclusionReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrimaryLanguage(t *testing.T) {
+	tests := []struct {
+		languages map[string]int64
+		want      string
+	}{
+		{map[string]int64{"Go": 10, "Shell": 5}, "Go"},
+		{map[string]int64{"Python": 7, "Go": 7}, "Go"},
+		{map[string]int64{}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := primaryLanguage(tt.languages); got != tt.want {
+			t.Errorf("primaryLanguage(%v) = %q, want %q", tt.languages, got, tt.want)
+		}
+	}
+}
@@ -1,13 +1,12 @@
 package cmd
 
 import (
-	"bytes"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 
 	"path/filepath"
@@ -120,7 +119,27 @@ type TemplatesConfig struct {
 	PRTemplates    []TemplateFile `yaml:"pr_templates,omitempty"`
 }
 
-type TemplatesHandler struct{}
+// files returns the content of every template file keyed by its path
+func (c TemplatesConfig) files() map[string]string {
+	allFiles := make(map[string]string)
+	for _, prTemplate := range c.PRTemplates {
+		allFiles[prTemplate.Path] = prTemplate.Content
+	}
+
+	for _, issueTemplate := range c.IssueTemplates {
+		allFiles[issueTemplate.Path] = issueTemplate.Content
+	}
+
+	for _, issueConfig := range c.IssueConfigs {
+		allFiles[issueConfig.Path] = issueConfig.Content
+	}
+	return allFiles
+}
+
+type TemplatesHandler struct {
+	Config *Config
+	DryRun bool
+}
 
 func (h *TemplatesHandler) Name() string {
 	return "templates"
@@ -142,6 +161,61 @@ func readTemplates(path string) (TemplatesConfig, error) {
 	return config, nil
 }
 
+func (h *TemplatesHandler) render(data interface{}, vars *repoVars) (interface{}, error) {
+	config, ok := data.(TemplatesConfig)
+	if !ok {
+		return nil, fmt.Errorf("invalid data type for TemplatesHandler")
+	}
+
+	renderFiles := func(files []TemplateFile) ([]TemplateFile, error) {
+		if files == nil {
+			return nil, nil
+		}
+		rendered := make([]TemplateFile, len(files))
+		for i, f := range files {
+			if err := vars.renderAll(&f.Content); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Path, err)
+			}
+			rendered[i] = f
+		}
+		return rendered, nil
+	}
+
+	var err error
+	if config.IssueTemplates, err = renderFiles(config.IssueTemplates); err != nil {
+		return nil, err
+	}
+	if config.IssueConfigs, err = renderFiles(config.IssueConfigs); err != nil {
+		return nil, err
+	}
+	if config.PRTemplates, err = renderFiles(config.PRTemplates); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (h *TemplatesHandler) newData() interface{} {
+	return &TemplatesConfig{}
+}
+
+func (h *TemplatesHandler) validateData(data interface{}) []fieldError {
+	config := data.(*TemplatesConfig)
+
+	var errs []fieldError
+	for key, files := range map[string][]TemplateFile{
+		"issue_templates": config.IssueTemplates,
+		"issue_configs":   config.IssueConfigs,
+		"pr_templates":    config.PRTemplates,
+	} {
+		for i, f := range files {
+			if f.Path == "" {
+				errs = append(errs, fieldErr("path is required", key, strconv.Itoa(i)))
+			}
+		}
+	}
+	return errs
+}
+
 func (h *TemplatesHandler) Load(path string) (interface{}, error) {
 	return readTemplates(path)
 }
@@ -265,31 +339,78 @@ const (
 	FileOperationTypeDelete FileOperationType = "delete"
 )
 
-func (h *TemplatesHandler) Push(client *gitea.Client, owner, repo string, data interface{}) error {
+func (h *TemplatesHandler) Push(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error) {
 	templatesConfig, ok := data.(TemplatesConfig)
 	if !ok {
-		return fmt.Errorf("invalid data type for TemplatesHandler")
+		return nil, fmt.Errorf("invalid data type for TemplatesHandler")
 	}
 
-	allFiles := make(map[string]string)
-	for _, prTemplate := range templatesConfig.PRTemplates {
-		allFiles[prTemplate.Path] = prTemplate.Content
+	r, baseBranch, allOps, err := h.planFileOperations(client, owner, repo, templatesConfig)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, issueTemplate := range templatesConfig.IssueTemplates {
-		allFiles[issueTemplate.Path] = issueTemplate.Content
+	changes := fileOperationChanges(allOps)
+	if len(allOps) == 0 {
+		return changes, nil
 	}
 
-	for _, issueConfig := range templatesConfig.IssueConfigs {
-		allFiles[issueConfig.Path] = issueConfig.Content
+	if h.DryRun {
+		for _, op := range allOps {
+			logger.Info("(dry run) would "+string(op.Operation)+" template file",
+				"owner", owner,
+				"repo", repo,
+				"path", op.Path,
+				"branch", DefaultTemplatesUpdateBranchName,
+			)
+		}
+		logger.Info("(dry run) would open pull request",
+			"owner", owner,
+			"repo", repo,
+			"head", DefaultTemplatesUpdateBranchName,
+			"base", r.DefaultBranch,
+		)
+		return changes, nil
 	}
 
+	opts := ChangeFilesOptions{
+		Message:   DefaultTemplatesUpdateCommitMessage,
+		Files:     allOps,
+		NewBranch: DefaultTemplatesUpdateBranchName,
+		Branch:    baseBranch,
+	}
+
+	cfg := h.Config
+
+	path := fmt.Sprintf("/repos/%s/%s/contents", owner, repo)
+	if err := giteaAPIRequest(cfg, http.MethodPost, path, opts, nil); err != nil {
+		return nil, fmt.Errorf("failed to update files: %w", err)
+	}
+
+	_, _, err = client.CreatePullRequest(owner, repo, gitea.CreatePullRequestOption{
+		Title: DefaultTemplatesUpdateCommitMessage,
+		Head:  DefaultTemplatesUpdateBranchName,
+		Body:  DefaultTemplatesUpdatePRDescription,
+		Base:  r.DefaultBranch,
+	})
+	if err != nil && !strings.Contains(err.Error(), "pull request already exists") {
+		return nil, fmt.Errorf("failed to create PR: %w", err)
+	}
+	return changes, nil
+}
+
+// planFileOperations works out which template files have to be created or
+// updated, relative to the sync branch if it already exists and to the
+// default branch otherwise.
+func (h *TemplatesHandler) planFileOperations(client *gitea.Client, owner, repo string, templatesConfig TemplatesConfig) (*gitea.Repository, string, []ChangeFileOperation, error) {
+	allFiles := templatesConfig.files()
+
 	toUpdate := make([]ChangeFileOperation, 0)
 	toCreate := make([]ChangeFileOperation, 0)
 
 	r, _, err := client.GetRepo(owner, repo)
 	if err != nil {
-		return fmt.Errorf("failed to get repository: %w", err)
+		return nil, "", nil, fmt.Errorf("failed to get repository: %w", err)
 	}
 
 	baseBranch := r.DefaultBranch
@@ -298,7 +419,14 @@ func (h *TemplatesHandler) Push(client *gitea.Client, owner, repo string, data i
 		baseBranch = existingUpdateBranch.Name
 	}
 
-	for path, content := range allFiles {
+	paths := make([]string, 0, len(allFiles))
+	for path := range allFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		content := allFiles[path]
 		existingFile, resp, err := client.GetContents(owner, repo, baseBranch, path)
 		if err != nil {
 			if resp != nil && resp.StatusCode == http.StatusNotFound {
@@ -310,13 +438,13 @@ func (h *TemplatesHandler) Push(client *gitea.Client, owner, repo string, data i
 				})
 				continue
 			}
-			return fmt.Errorf("failed to get content for '%s': %w", path, err)
+			return nil, "", nil, fmt.Errorf("failed to get content for '%s': %w", path, err)
 		}
 
 		if existingFile.Content != nil {
 			decodedContent, err := base64.StdEncoding.DecodeString(*existingFile.Content)
 			if err != nil {
-				return fmt.Errorf("failed to decode existing file '%s': %w", path, err)
+				return nil, "", nil, fmt.Errorf("failed to decode existing file '%s': %w", path, err)
 			}
 			if string(decodedContent) != content {
 				toUpdate = append(toUpdate, ChangeFileOperation{
@@ -329,64 +457,64 @@ func (h *TemplatesHandler) Push(client *gitea.Client, owner, repo string, data i
 		}
 	}
 
-	allOps := append(toUpdate, toCreate...)
-	if len(allOps) == 0 {
-		return nil
-	}
+	return r, baseBranch, append(toUpdate, toCreate...), nil
+}
 
-	opts := ChangeFilesOptions{
-		Message:   DefaultTemplatesUpdateCommitMessage,
-		Files:     allOps,
-		NewBranch: DefaultTemplatesUpdateBranchName,
-		Branch:    baseBranch,
+func (h *TemplatesHandler) Plan(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error) {
+	templatesConfig, ok := data.(TemplatesConfig)
+	if !ok {
+		return nil, fmt.Errorf("invalid data type for TemplatesHandler")
 	}
 
-	jsonData, err := json.Marshal(opts)
+	_, _, ops, err := h.planFileOperations(client, owner, repo, templatesConfig)
 	if err != nil {
-		return fmt.Errorf("failed to marshal options: %w", err)
+		return nil, err
 	}
 
-	cfg, err := LoadConfig(cfgFile)
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
-	}
+	return fileOperationChanges(ops), nil
+}
 
-	url := fmt.Sprintf("%s/api/v1/repos/%s/%s/contents", cfg.GiteaURL, owner, repo)
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+// Diff only reports template files that are missing or differ; files that
+// exist in the repo but not in the YAML are never deleted by push.
+func (h *TemplatesHandler) Diff(live, desired interface{}) ([]Change, error) {
+	liveTemplates, ok := live.(TemplatesConfig)
+	desiredTemplates, ok2 := desired.(TemplatesConfig)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("invalid data type for TemplatesHandler")
 	}
 
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Authorization", fmt.Sprintf("token %s", cfg.GiteaToken))
+	liveFiles := liveTemplates.files()
+	desiredFiles := desiredTemplates.files()
 
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+	paths := make([]string, 0, len(desiredFiles))
+	for path := range desiredFiles {
+		paths = append(paths, path)
 	}
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusCreated {
-		body, err := io.ReadAll(response.Body)
-		if err != nil {
-			return fmt.Errorf(
-				"failed to update files, status %d (also failed to read response body: %v)",
-				response.StatusCode, err,
-			)
+	sort.Strings(paths)
+
+	var changes []Change
+	for _, path := range paths {
+		content, ok := liveFiles[path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Action: ChangeActionCreate, Item: path})
+		case content != desiredFiles[path]:
+			changes = append(changes, Change{Action: ChangeActionUpdate, Item: path})
 		}
-		return fmt.Errorf("failed to update files: %s", string(body))
 	}
+	return changes, nil
+}
 
-	_, _, err = client.CreatePullRequest(owner, repo, gitea.CreatePullRequestOption{
-		Title: DefaultTemplatesUpdateCommitMessage,
-		Head:  DefaultTemplatesUpdateBranchName,
-		Body:  DefaultTemplatesUpdatePRDescription,
-		Base:  r.DefaultBranch,
-	})
-	if err != nil && !strings.Contains(err.Error(), "pull request already exists") {
-		return fmt.Errorf("failed to create PR: %w", err)
+func fileOperationChanges(ops []ChangeFileOperation) []Change {
+	changes := make([]Change, 0, len(ops))
+	for _, op := range ops {
+		action := ChangeActionCreate
+		if op.Operation == FileOperationTypeUpdate {
+			action = ChangeActionUpdate
+		}
+		changes = append(changes, Change{Action: action, Item: op.Path})
 	}
-	return nil
+	return changes
 }
 
 func (h *TemplatesHandler) Enabled() bool {
@@ -3,16 +3,57 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"code.gitea.io/sdk/gitea"
 	"gopkg.in/yaml.v3"
 )
 
 type TopicsConfig struct {
-	Topics []string `yaml:"topics"`
+	Topics       []string `yaml:"topics"`
+	TopicsRemove []string `yaml:"topics_remove,omitempty"`
 }
 
-type TopicsHandler struct{}
+const (
+	maxTopics      = 25
+	maxTopicLength = 35
+)
+
+// topicPattern is the format Gitea requires for topics
+var topicPattern = regexp.MustCompile(`^[a-z0-9][-.a-z0-9]*$`)
+
+// normalizeTopic returns a topic the way Gitea stores it
+func normalizeTopic(topic string) string {
+	return strings.ToLower(strings.TrimSpace(topic))
+}
+
+// checkTopics returns an error for every topic Gitea would reject, even
+// after normalizing it
+func checkTopics(topics []string, path ...string) []fieldError {
+	var errs []fieldError
+	for i, topic := range topics {
+		topicPath := append(append([]string{}, path...), strconv.Itoa(i))
+		// placeholders are checked once they are rendered for a repo
+		if strings.Contains(topic, "{{") {
+			continue
+		}
+		normalized := normalizeTopic(topic)
+		switch {
+		case !topicPattern.MatchString(normalized):
+			errs = append(errs, fieldErr(fmt.Sprintf("invalid topic %q (must start with a letter or number and can only contain letters, numbers, dashes and dots)", topic), topicPath...))
+		case len(normalized) > maxTopicLength:
+			errs = append(errs, fieldErr(fmt.Sprintf("topic %q is longer than %d characters", topic, maxTopicLength), topicPath...))
+		}
+	}
+	return errs
+}
+
+type TopicsHandler struct {
+	Config *Config
+	DryRun bool
+}
 
 func (h *TopicsHandler) Name() string {
 	return "topics"
@@ -23,7 +64,7 @@ func (h *TopicsHandler) Path() string {
 }
 
 func (h *TopicsHandler) Pull(client *gitea.Client, owner, repo string) (interface{}, error) {
-	topics, _, err := client.ListRepoTopics(owner, repo, gitea.ListRepoTopicsOptions{})
+	topics, err := listRepoTopics(client, owner, repo)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get topics for %s/%s: %w", owner, repo, err)
 	}
@@ -31,49 +72,126 @@ func (h *TopicsHandler) Pull(client *gitea.Client, owner, repo string) (interfac
 	return TopicsConfig{Topics: topics}, nil
 }
 
-func (h *TopicsHandler) Push(client *gitea.Client, owner, repo string, data interface{}) error {
+func (h *TopicsHandler) Push(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error) {
+	changes, topics, err := h.plan(client, owner, repo, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// all topics are set in a single request rather than one per topic
+	if h.DryRun || len(changes) == 0 {
+		return applyChanges(h.Name(), owner, repo, changes, h.DryRun)
+	}
+
+	if _, err := client.SetRepoTopics(owner, repo, topics); err != nil {
+		return nil, fmt.Errorf("failed to set topics: %w", err)
+	}
+	return changes, nil
+}
+
+func (h *TopicsHandler) Plan(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error) {
+	changes, _, err := h.plan(client, owner, repo, data)
+	return changes, err
+}
+
+// plan returns the changes to the topics of a repo and all topics it should
+// have afterwards
+func (h *TopicsHandler) plan(client *gitea.Client, owner, repo string, data interface{}) ([]Change, []string, error) {
 	topicsConfig, ok := data.(TopicsConfig)
 	if !ok {
-		return fmt.Errorf("invalid data type for TopicsHandler")
+		return nil, nil, fmt.Errorf("invalid data type for TopicsHandler")
+	}
+
+	strategy, err := h.updateStrategy()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if len(topicsConfig.Topics) == 0 {
-		return nil
+	// invalid topics would make Gitea reject the whole request
+	if errs := checkTopics(topicsConfig.Topics, "topics"); len(errs) > 0 {
+		return nil, nil, fmt.Errorf("invalid topics: %s", errs[0].Msg)
+	}
+	if errs := checkTopics(topicsConfig.TopicsRemove, "topics_remove"); len(errs) > 0 {
+		return nil, nil, fmt.Errorf("invalid topics_remove: %s", errs[0].Msg)
 	}
 
-	cfg, err := LoadConfig(cfgFile)
+	existing, err := listRepoTopics(client, owner, repo)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return nil, nil, fmt.Errorf("failed to get topics for %s/%s: %w", owner, repo, err)
 	}
 
-	strategy := cfg.TopicsUpdateStrategy
-	if err := h.validateUpdateStrategy(strategy); err != nil {
-		return err
+	topics := desiredTopics(existing, topicsConfig, strategy)
+	if len(topics) > maxTopics {
+		return nil, nil, fmt.Errorf("%s/%s would have %d topics, Gitea allows at most %d", owner, repo, len(topics), maxTopics)
+	}
+
+	return diffItems(existing, topics, func(topic string) string {
+		return topic
+	}), topics, nil
+}
+
+func (h *TopicsHandler) Diff(live, desired interface{}) ([]Change, error) {
+	liveTC, ok := live.(TopicsConfig)
+	desiredTC, ok2 := desired.(TopicsConfig)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("invalid data type for TopicsHandler")
 	}
 
-	if strategy == UpdateStrategyReplace {
-		_, err := client.SetRepoTopics(owner, repo, topicsConfig.Topics)
-		return err
+	strategy, err := h.updateStrategy()
+	if err != nil {
+		return nil, err
 	}
 
-	for _, topic := range topicsConfig.Topics {
-		_, err := client.AddRepoTopic(owner, repo, topic)
-		if err != nil {
-			return err
+	return diffItems(liveTC.Topics, desiredTopics(liveTC.Topics, desiredTC, strategy), func(topic string) string {
+		return topic
+	}), nil
+}
+
+// desiredTopics returns the topics a repo with the existing topics should
+// have. Without a topics list, only the topics to remove are removed.
+func desiredTopics(existing []string, tc TopicsConfig, strategy UpdateStrategy) []string {
+	remove := make(map[string]bool, len(tc.TopicsRemove))
+	for _, topic := range tc.TopicsRemove {
+		remove[normalizeTopic(topic)] = true
+	}
+
+	var candidates []string
+	if tc.Topics == nil || strategy == UpdateStrategyAppend {
+		candidates = append(candidates, existing...)
+	}
+	for _, topic := range tc.Topics {
+		candidates = append(candidates, normalizeTopic(topic))
+	}
+
+	topics := []string{}
+	seen := make(map[string]bool, len(candidates))
+	for _, topic := range candidates {
+		if remove[topic] || seen[topic] {
+			continue
 		}
+		seen[topic] = true
+		topics = append(topics, topic)
 	}
+	return topics
+}
 
-	return nil
+func (h *TopicsHandler) updateStrategy() (UpdateStrategy, error) {
+	strategy := h.Config.TopicsUpdateStrategy
+	if err := h.validateUpdateStrategy(strategy); err != nil {
+		return "", err
+	}
+	return strategy, nil
 }
 
 func (h *TopicsHandler) validateUpdateStrategy(strategy UpdateStrategy) error {
 	supported := map[UpdateStrategy]bool{
 		UpdateStrategyReplace: true,
 		UpdateStrategyAppend:  true,
+		UpdateStrategySync:    true,
 	}
 
 	if _, ok := supported[strategy]; !ok {
-		return fmt.Errorf("invalid topic_update_strategy: %s (must be 'replace' or 'append')", strategy)
+		return fmt.Errorf("invalid topic_update_strategy: %s (must be 'replace', 'append' or 'sync')", strategy)
 	}
 
 	return nil
@@ -83,10 +201,63 @@ func (h *TopicsHandler) Enabled() bool {
 	return true
 }
 
+func (h *TopicsHandler) render(data interface{}, vars *repoVars) (interface{}, error) {
+	tc, ok := data.(TopicsConfig)
+	if !ok {
+		return nil, fmt.Errorf("invalid data type for TopicsHandler")
+	}
+
+	// nil lists stay nil, since a missing topics list only removes topics
+	renderList := func(topics []string) ([]string, error) {
+		if topics == nil {
+			return nil, nil
+		}
+		rendered := make([]string, len(topics))
+		for i, topic := range topics {
+			var err error
+			if rendered[i], err = vars.render(topic); err != nil {
+				return nil, err
+			}
+		}
+		return rendered, nil
+	}
+
+	topics, err := renderList(tc.Topics)
+	if err != nil {
+		return nil, err
+	}
+	remove, err := renderList(tc.TopicsRemove)
+	if err != nil {
+		return nil, err
+	}
+	return TopicsConfig{Topics: topics, TopicsRemove: remove}, nil
+}
+
+func (h *TopicsHandler) newData() interface{} {
+	return &TopicsConfig{}
+}
+
+func (h *TopicsHandler) validateData(data interface{}) []fieldError {
+	tc := data.(*TopicsConfig)
+
+	errs := checkTopics(tc.Topics, "topics")
+	errs = append(errs, checkTopics(tc.TopicsRemove, "topics_remove")...)
+	if len(tc.Topics) > maxTopics {
+		errs = append(errs, fieldErr(fmt.Sprintf("at most %d topics are allowed", maxTopics), "topics"))
+	}
+	return errs
+}
+
 func (h *TopicsHandler) Load(path string) (interface{}, error) {
 	return readTopics(path)
 }
 
+func listRepoTopics(client *gitea.Client, owner, repo string) ([]string, error) {
+	return listAll(func(opts gitea.ListOptions) ([]string, *gitea.Response, error) {
+		return client.ListRepoTopics(owner, repo, gitea.ListRepoTopicsOptions{ListOptions: opts})
+	})
+}
+
 func readTopics(path string) (TopicsConfig, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
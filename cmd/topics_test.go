@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTopicsRender(t *testing.T) {
+	vars := &repoVars{Owner: "MyOrg", Repo: "payment-service", Vars: map[string]string{"team": "platform"}}
+
+	tests := []struct {
+		name string
+		in   TopicsConfig
+		want TopicsConfig
+	}{
+		{
+			name: "placeholders are rendered",
+			in:   TopicsConfig{Topics: []string{"team-{{ .Vars.team }}", "go"}, TopicsRemove: []string{"{{ .Repo }}-legacy"}},
+			want: TopicsConfig{Topics: []string{"team-platform", "go"}, TopicsRemove: []string{"payment-service-legacy"}},
+		},
+		{
+			name: "missing topics list stays missing",
+			in:   TopicsConfig{TopicsRemove: []string{"legacy"}},
+			want: TopicsConfig{TopicsRemove: []string{"legacy"}},
+		},
+		{
+			name: "empty topics list stays empty",
+			in:   TopicsConfig{Topics: []string{}},
+			want: TopicsConfig{Topics: []string{}},
+		},
+	}
+
+	h := &TopicsHandler{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.render(tt.in, vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("render() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDesiredTopics(t *testing.T) {
+	existing := []string{"go", "legacy", "backend"}
+
+	tests := []struct {
+		name     string
+		tc       TopicsConfig
+		strategy UpdateStrategy
+		want     []string
+	}{
+		{"replace", TopicsConfig{Topics: []string{"API", " go "}}, UpdateStrategyReplace, []string{"api", "go"}},
+		{"sync", TopicsConfig{Topics: []string{"api"}}, UpdateStrategySync, []string{"api"}},
+		{"append keeps existing topics", TopicsConfig{Topics: []string{"api", "Go"}}, UpdateStrategyAppend, []string{"go", "legacy", "backend", "api"}},
+		{"remove with append", TopicsConfig{Topics: []string{"api"}, TopicsRemove: []string{"Legacy"}}, UpdateStrategyAppend, []string{"go", "backend", "api"}},
+		{"remove wins over topics", TopicsConfig{Topics: []string{"api", "legacy"}, TopicsRemove: []string{"legacy"}}, UpdateStrategyReplace, []string{"api"}},
+		{"without topics only removes", TopicsConfig{TopicsRemove: []string{"legacy"}}, UpdateStrategyReplace, []string{"go", "backend"}},
+		{"empty topics removes all", TopicsConfig{Topics: []string{}}, UpdateStrategySync, []string{}},
+		{"duplicates", TopicsConfig{Topics: []string{"api", "API"}}, UpdateStrategyReplace, []string{"api"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := desiredTopics(existing, tt.tc, tt.strategy); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("desiredTopics() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTopics(t *testing.T) {
+	tests := []struct {
+		topic string
+		valid bool
+	}{
+		{"backend", true},
+		{"Go", true},
+		{" api-v2.1 ", true},
+		{"9lives", true},
+		{"-leading-dash", false},
+		{".leading-dot", false},
+		{"has space", false},
+		{"under_score", false},
+		{"", false},
+		{"a2345678901234567890123456789012345", true},
+		{"a23456789012345678901234567890123456", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			errs := checkTopics([]string{tt.topic}, "topics")
+			if valid := len(errs) == 0; valid != tt.valid {
+				t.Errorf("checkTopics(%q) = %v, want valid %v", tt.topic, errs, tt.valid)
+			}
+		})
+	}
+}
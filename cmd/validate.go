@@ -0,0 +1,590 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// validateCmd checks the config file and the settings files without talking to Gitea
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config file and settings files for errors",
+	Long: `Strictly parses the config file and every settings file that push would
+use, including per-repo overrides. Unknown keys, values of the wrong type and
+invalid values such as unsupported update strategies are reported with file
+and line. The same check runs before every push.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateConfigFiles(cfgFile); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), "✅ configuration is valid")
+		return nil
+	},
+}
+
+// validatedHandler is implemented by handlers whose settings files can be
+// checked by the validate command
+type validatedHandler interface {
+	// newData returns a pointer to an empty value of the data the handler loads
+	newData() interface{}
+	// validateData checks the values of the data decoded into newData()
+	validateData(data interface{}) []fieldError
+}
+
+// fieldError is an invalid value at a path of keys and list indexes in a YAML file
+type fieldError struct {
+	Path []string
+	Msg  string
+}
+
+func fieldErr(msg string, path ...string) fieldError {
+	return fieldError{Path: path, Msg: msg}
+}
+
+// validationError is a problem found in a YAML file
+type validationError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e validationError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Msg)
+}
+
+var (
+	supportedMergeStyles     = []string{"merge", "rebase", "rebase-merge", "squash", "fast-forward-only"}
+	supportedTrackerStyles   = []string{"numeric", "alphanumeric", "regexp"}
+	supportedProjectsModes   = []string{"repo", "owner", "all"}
+	supportedWebhookTypes    = []string{"gitea", "gogs", "slack", "discord", "dingtalk", "telegram", "msteams", "feishu", "matrix", "wechatwork", "packagist"}
+	supportedWebhookContents = []string{"json", "form"}
+)
+
+// checkEnum returns an error if value is set, is not a placeholder and is not one of allowed
+func checkEnum(value string, allowed []string, path ...string) []fieldError {
+	if value == "" || strings.Contains(value, "{{") {
+		return nil
+	}
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return []fieldError{fieldErr(fmt.Sprintf("invalid value %q (must be one of: %s)", value, strings.Join(allowed, ", ")), path...)}
+}
+
+var placeholderPattern = regexp.MustCompile(`{{[^}]*}}`)
+
+// checkURL returns an error if value is set and is not an http or https URL.
+// Placeholders such as {{ .Repo }} are allowed anywhere after the scheme.
+func checkURL(value string, path ...string) []fieldError {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(placeholderPattern.ReplaceAllString(value, "x"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return []fieldError{fieldErr(fmt.Sprintf("invalid URL %q (must be an http or https URL)", value), path...)}
+	}
+	return nil
+}
+
+// validateConfigFiles checks the config file and the settings files of every
+// enabled push handler and returns all problems found
+func validateConfigFiles(configPath string) error {
+	if configPath == "" {
+		configPath = DefaultConfigFile
+	}
+
+	// a .env file is optional; it may define the variables used in the config
+	_ = godotenv.Load()
+
+	b, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	root := &yaml.Node{}
+	if err := yaml.Unmarshal(b, root); err != nil {
+		return joinValidationErrors(yamlErrors(configPath, err))
+	}
+	expandEnvVars(root)
+
+	var cfg Config
+	errs := decodeNodeStrict(configPath, root, &cfg)
+	cfg.source = root
+
+	errs = append(errs, validateConnection(configPath, &cfg, root)...)
+
+	type scope struct {
+		name  string
+		cfg   *Config
+		nodes []*yaml.Node
+	}
+	scopes := []scope{{cfg: &cfg, nodes: []*yaml.Node{root}}}
+	if len(cfg.Groups) > 0 {
+		scopes = nil
+		seen := make(map[string]bool)
+		for i, g := range cfg.Groups {
+			groupNode := lookupNode(root, "groups", strconv.Itoa(i))
+			if seen[g.Name] {
+				errs = append(errs, validationError{configPath, nodeLine(groupNode), fmt.Sprintf("group %q is defined more than once", g.Name)})
+				continue
+			}
+			seen[g.Name] = true
+
+			gc, err := cfg.groupConfig(g)
+			if err != nil {
+				errs = append(errs, validationError{configPath, nodeLine(groupNode), err.Error()})
+				continue
+			}
+			scopes = append(scopes, scope{name: g.Name, cfg: gc, nodes: []*yaml.Node{groupNode, root}})
+		}
+	}
+
+	validatedFiles := make(map[string]bool)
+	for _, s := range scopes {
+		for _, fe := range validateConfigValues(s.cfg) {
+			msg := fe.Msg
+			if s.name != "" {
+				msg = fmt.Sprintf("group %q: %s", s.name, msg)
+			}
+			errs = append(errs, validationError{configPath, lineIn(s.nodes, fe.Path...), msg})
+		}
+
+		outputDir := resolveOutputDir(s.cfg)
+		for _, handler := range pushHandlers(s.cfg, true) {
+			vh, ok := handler.(validatedHandler)
+			if !ok {
+				continue
+			}
+
+			path := filepath.Join(outputDir, handler.Path())
+			overrides, _ := filepath.Glob(filepath.Join(outputDir, DefaultOverridesDir, "*", "*", handler.Path()))
+			if !validatedFiles[path] {
+				validatedFiles[path] = true
+				errs = append(errs, validateSettingsFile(path, vh, len(overrides) > 0)...)
+			}
+
+			for _, override := range overrides {
+				if !validatedFiles[override] {
+					validatedFiles[override] = true
+					errs = append(errs, validateOverrideFile(override, vh)...)
+				}
+			}
+		}
+	}
+
+	return joinValidationErrors(errs)
+}
+
+// validateConnection checks that Gitea URL and token are configured, without
+// reading the token
+func validateConnection(file string, cfg *Config, root *yaml.Node) []validationError {
+	var errs []validationError
+
+	giteaURL := cfg.GiteaURL
+	if env := os.Getenv("GITEA_URL"); env != "" {
+		giteaURL = env
+	}
+	if giteaURL == "" {
+		errs = append(errs, validationError{file, 0, "missing Gitea URL - configure gitea_url or GITEA_URL env"})
+	} else if u, err := url.Parse(giteaURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, validationError{file, lineIn([]*yaml.Node{root}, "gitea_url"), fmt.Sprintf("invalid gitea_url %q (must be an http or https URL)", giteaURL)})
+	}
+
+	var sources []string
+	for key, value := range map[string]string{
+		"gitea_token":         cfg.GiteaToken,
+		"gitea_token_file":    cfg.GiteaTokenFile,
+		"gitea_token_command": cfg.GiteaTokenCommand,
+	} {
+		if value != "" {
+			sources = append(sources, key)
+		}
+	}
+	sort.Strings(sources)
+	switch {
+	case len(sources) > 1:
+		errs = append(errs, validationError{file, lineIn([]*yaml.Node{root}, sources[1]), "only one of gitea_token, gitea_token_file and gitea_token_command can be set"})
+	case len(sources) == 0 && os.Getenv("GITEA_TOKEN") == "":
+		errs = append(errs, validationError{file, 0, "missing Gitea token - configure gitea_token, gitea_token_file, gitea_token_command or GITEA_TOKEN env"})
+	}
+
+	return errs
+}
+
+// validateConfigValues checks the values of a config that has already been decoded
+func validateConfigValues(cfg *Config) []fieldError {
+	var errs []fieldError
+
+	if cfg.Concurrency < 0 {
+		errs = append(errs, fieldErr("concurrency must not be negative", "concurrency"))
+	}
+
+	strategies := []struct {
+		enabled  bool
+		key      string
+		strategy UpdateStrategy
+		validate func(UpdateStrategy) error
+	}{
+		{cfg.Push.Topics, "topics_update_strategy", cfg.TopicsUpdateStrategy, (&TopicsHandler{}).validateUpdateStrategy},
+		{cfg.Push.BranchProtections, "branch_protections_update_strategy", cfg.BranchProtectionsUpdateStrategy, (&BranchProtectionsHandler{}).validateUpdateStrategy},
+		{cfg.Push.TagProtections, "tag_protections_update_strategy", cfg.TagProtectionsUpdateStrategy, (&TagProtectionsHandler{}).validateUpdateStrategy},
+		{cfg.Push.Webhooks, "webhooks_update_strategy", cfg.WebhooksUpdateStrategy, (&WebhooksHandler{}).validateUpdateStrategy},
+	}
+	for _, s := range strategies {
+		if !s.enabled && s.strategy == "" {
+			continue
+		}
+		if err := s.validate(s.strategy); err != nil {
+			errs = append(errs, fieldErr(err.Error(), s.key))
+		}
+	}
+
+	t := cfg.Targets
+	if err := t.RepoSelector.validate(); err != nil {
+		errs = append(errs, fieldErr(err.Error(), "targets", "visibility"))
+	}
+	if t.Autodiscover && len(discoverySources(cfg)) == 0 {
+		errs = append(errs, fieldErr("autodiscover is enabled but no organization, users or instance are set", "targets", "autodiscover"))
+	}
+
+	checkPatterns := func(patterns []string, path ...string) {
+		if _, err := newRepoMatcher(patterns); err != nil {
+			errs = append(errs, fieldErr(err.Error(), path...))
+		}
+	}
+	checkPatterns(t.AutodiscoverFilter, "targets", "autodiscover_filter")
+	checkPatterns(t.ExcludeRepos, "targets", "exclude_repos")
+	checkPatterns(t.InstanceFilter, "targets", "instance_filter")
+	checkPatterns(t.InstanceExclude, "targets", "instance_exclude")
+	for key, sources := range map[string][]RepoSource{"organizations": t.Organizations, "users": t.Users} {
+		for i, s := range sources {
+			if s.Name == "" {
+				errs = append(errs, fieldErr("name is required", "targets", key, strconv.Itoa(i)))
+			}
+			checkPatterns(s.Filter, "targets", key, strconv.Itoa(i), "filter")
+			checkPatterns(s.Exclude, "targets", key, strconv.Itoa(i), "exclude")
+		}
+	}
+
+	return errs
+}
+
+// validateSettingsFile strictly decodes a settings file and checks its values.
+// A missing file is only an error if there are no overrides to push instead.
+func validateSettingsFile(path string, handler validatedHandler, hasOverrides bool) []validationError {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && hasOverrides {
+		return nil
+	}
+	if err != nil {
+		return []validationError{{path, 0, fmt.Sprintf("failed to read settings file: %v", err)}}
+	}
+
+	data := handler.newData()
+	root, errs := decodeStrict(path, b, data)
+	if root == nil {
+		return errs
+	}
+
+	for _, fe := range handler.validateData(data) {
+		errs = append(errs, validationError{path, lineIn([]*yaml.Node{root}, fe.Path...), fe.Msg})
+	}
+	return errs
+}
+
+// validateOverrideFile checks an override file for unknown keys. Overrides
+// are partial, so their values are only checked after merging.
+func validateOverrideFile(path string, handler validatedHandler) []validationError {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return []validationError{{path, 0, fmt.Sprintf("failed to read override file: %v", err)}}
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return yamlErrors(path, err)
+	}
+	return withFile(path, checkKnownFields(&root, reflect.TypeOf(handler.newData()), "", true))
+}
+
+// decodeStrict decodes b into out and reports unknown keys and type errors
+// with their line. It returns the root node of the document.
+func decodeStrict(file string, b []byte, out interface{}) (*yaml.Node, []validationError) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return nil, yamlErrors(file, err)
+	}
+	return &root, decodeNodeStrict(file, &root, out)
+}
+
+// decodeNodeStrict is decodeStrict for YAML that is already parsed
+func decodeNodeStrict(file string, root *yaml.Node, out interface{}) []validationError {
+	errs := withFile(file, checkKnownFields(root, reflect.TypeOf(out), "", false))
+	if err := root.Decode(out); err != nil {
+		errs = append(errs, yamlErrors(file, err)...)
+	}
+	return errs
+}
+
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlErrors turns the errors of the YAML decoder into validation errors
+func yamlErrors(file string, err error) []validationError {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	errs := make([]validationError, 0, len(messages))
+	for _, msg := range messages {
+		if m := yamlErrorLine.FindStringSubmatch(msg); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			errs = append(errs, validationError{file, line, m[2]})
+			continue
+		}
+		errs = append(errs, validationError{file, 0, strings.TrimPrefix(msg, "yaml: ")})
+	}
+	return errs
+}
+
+func withFile(file string, errs []validationError) []validationError {
+	for i := range errs {
+		errs[i].File = file
+	}
+	return errs
+}
+
+// checkKnownFields reports every key in node that has no field in t. Keys of
+// override files may end in "+" or "-". Unknown keys are reported by their
+// path below prefix, e.g. "rules[0].enable_pushh".
+func checkKnownFields(node *yaml.Node, t reflect.Type, prefix string, overlay bool) []validationError {
+	if node == nil {
+		return nil
+	}
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		node = node.Content[0]
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// groups are configs of their own, with a name
+	if t == reflect.TypeOf(TargetGroup{}) {
+		return checkMappingKeys(node, reflect.TypeOf(Config{}), prefix, overlay, "name")
+	}
+	// other types with their own decoding are checked by decoding them
+	if reflect.PointerTo(t).Implements(reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == reflect.TypeOf(yaml.Node{}) {
+			return nil
+		}
+		return checkMappingKeys(node, t, prefix, overlay)
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		var errs []validationError
+		for i, item := range node.Content {
+			errs = append(errs, checkKnownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", prefix, i), overlay)...)
+		}
+		return errs
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var errs []validationError
+		for i := 1; i < len(node.Content); i += 2 {
+			errs = append(errs, checkKnownFields(node.Content[i], t.Elem(), joinKey(prefix, node.Content[i-1].Value), overlay)...)
+		}
+		return errs
+	default:
+		return nil
+	}
+}
+
+func checkMappingKeys(node *yaml.Node, t reflect.Type, prefix string, overlay bool, extraKeys ...string) []validationError {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	fields := yamlFields(t)
+	var errs []validationError
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		if overlay {
+			if name, ok := strings.CutSuffix(key, "-"); ok {
+				// removals list the items to remove, possibly by name only
+				if _, known := fields[name]; !known {
+					errs = append(errs, unknownFieldError(node.Content[i], joinKey(prefix, name)))
+				}
+				continue
+			}
+			key = strings.TrimSuffix(key, "+")
+		}
+
+		fieldType, ok := fields[key]
+		if !ok {
+			if !containsFold(extraKeys, key) {
+				errs = append(errs, unknownFieldError(node.Content[i], joinKey(prefix, key)))
+			}
+			continue
+		}
+		errs = append(errs, checkKnownFields(node.Content[i+1], fieldType, joinKey(prefix, key), overlay)...)
+	}
+	return errs
+}
+
+func unknownFieldError(keyNode *yaml.Node, path string) validationError {
+	return validationError{Line: keyNode.Line, Msg: fmt.Sprintf("unknown field %q", path)}
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// yamlFields returns the YAML keys of the fields of struct type t with the
+// types of the fields, following the naming rules of the YAML decoder
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(opts, "inline") {
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			for k, v := range yamlFields(ft) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// lookupNode returns the node at a path of mapping keys and list indexes,
+// or nil if there is none
+func lookupNode(node *yaml.Node, path ...string) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, key := range path {
+		switch node.Kind {
+		case yaml.MappingNode:
+			var next *yaml.Node
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					next = node.Content[i+1]
+					break
+				}
+			}
+			if next == nil {
+				return nil
+			}
+			node = next
+		case yaml.SequenceNode:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node.Content) {
+				return nil
+			}
+			node = node.Content[i]
+		default:
+			return nil
+		}
+	}
+	return node
+}
+
+// lineIn returns the line of the deepest node along path in the first of the
+// nodes that contains it
+func lineIn(nodes []*yaml.Node, path ...string) int {
+	for _, node := range nodes {
+		for n := len(path); n > 0; n-- {
+			if found := lookupNode(node, path[:n]...); found != nil {
+				return found.Line
+			}
+		}
+	}
+	return nodeLine(nodes[0])
+}
+
+func nodeLine(node *yaml.Node) int {
+	if node == nil {
+		return 0
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		return node.Content[0].Line
+	}
+	return node.Line
+}
+
+// joinValidationErrors combines the errors, sorted by file and line, into one
+func joinValidationErrors(errs []validationError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].File != errs[j].File {
+			return errs[i].File < errs[j].File
+		}
+		return errs[i].Line < errs[j].Line
+	})
+
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = "  " + e.Error()
+	}
+	return fmt.Errorf("invalid configuration:\n%s", strings.Join(lines, "\n"))
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
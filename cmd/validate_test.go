@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigFiles(t *testing.T) {
+	t.Setenv("GITEA_URL", "")
+	t.Setenv("GITEA_TOKEN", "")
+
+	const header = "gitea_url: https://gitea.example.com\ngitea_token: token\nconfig:\n  output_dir: ${TEST_OUTPUT_DIR}\n"
+
+	tests := []struct {
+		name   string
+		config string
+		files  map[string]string
+		// want are substrings of the error, each with the file and line
+		want []string
+	}{
+		{
+			name:   "valid",
+			config: header + "push:\n  repo_settings: true\n  topics: true\ntopics_update_strategy: sync\n",
+			files: map[string]string{
+				"repo_settings.yaml": "default_merge_style: squash\n",
+				"topics.yaml":        "topics: [go, backend]\n",
+			},
+		},
+		{
+			name:   "unknown config key",
+			config: header + "dry_runn: true\n",
+			want:   []string{`config.yaml:5: unknown field "dry_runn"`},
+		},
+		{
+			name:   "wrong type",
+			config: header + "concurrency: many\n",
+			want:   []string{"config.yaml:5: cannot unmarshal"},
+		},
+		{
+			name:   "unsupported update strategy",
+			config: header + "push:\n  topics: true\ntopics_update_strategy: merge\n",
+			files:  map[string]string{"topics.yaml": "topics: [go]\n"},
+			want:   []string{"config.yaml:7: invalid topic_update_strategy: merge"},
+		},
+		{
+			name:   "missing settings file",
+			config: header + "push:\n  repo_settings: true\n",
+			want:   []string{"repo_settings.yaml: failed to read settings file"},
+		},
+		{
+			name:   "invalid settings values",
+			config: header + "push:\n  repo_settings: true\n  topics: true\ntopics_update_strategy: append\n",
+			files: map[string]string{
+				"repo_settings.yaml": "has_issues: true\ndefault_merge_style: octopus\n",
+				"topics.yaml":        "topics:\n  - go\n  - -invalid\n",
+			},
+			want: []string{
+				`repo_settings.yaml:2: invalid value "octopus"`,
+				`topics.yaml:3: invalid topic "-invalid"`,
+			},
+		},
+		{
+			name:   "unknown key in an override",
+			config: header + "push:\n  repo_settings: true\n",
+			files: map[string]string{
+				"repo_settings.yaml":                     "has_issues: true\n",
+				"overrides/MyOrg/api/repo_settings.yaml": "has_isues: false\n",
+			},
+			want: []string{`repo_settings.yaml:1: unknown field "has_isues"`},
+		},
+		{
+			name:   "invalid group",
+			config: header + "groups:\n  - name: a\n    concurrency: -1\n    targets:\n      repos: [MyOrg/api]\n",
+			want:   []string{`config.yaml:7: group "a": concurrency must not be negative`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			outputDir := filepath.Join(dir, "defaults")
+			t.Setenv("TEST_OUTPUT_DIR", outputDir)
+
+			configPath := filepath.Join(dir, "config.yaml")
+			writeTestFile(t, configPath, tt.config)
+			for name, content := range tt.files {
+				writeTestFile(t, filepath.Join(outputDir, name), content)
+			}
+
+			err := validateConfigFiles(configPath)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("validateConfigFiles() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validateConfigFiles() succeeded, want %v", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("validateConfigFiles() = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"code.gitea.io/sdk/gitea"
+	"gopkg.in/yaml.v3"
+)
+
+// repoVars are the values available to placeholders such as {{ .Repo }} in
+// the settings of a repo
+type repoVars struct {
+	Owner string
+	Repo  string
+	// Vars are the user-defined variables of the repo, e.g. {{ .Vars.jira_key }}
+	Vars map[string]string
+
+	client        *gitea.Client
+	defaultBranch string
+}
+
+// newRepoVars returns the variables of a repo: the global variables of the
+// config overlaid with the ones defined for the repo
+func newRepoVars(client *gitea.Client, cfg *Config, owner, repo string) *repoVars {
+	vars := make(map[string]string, len(cfg.Variables))
+	for k, v := range cfg.Variables {
+		vars[k] = v
+	}
+	for fullName, repoVariables := range cfg.RepoVariables {
+		if !strings.EqualFold(fullName, owner+"/"+repo) {
+			continue
+		}
+		for k, v := range repoVariables {
+			vars[k] = v
+		}
+	}
+
+	return &repoVars{Owner: owner, Repo: repo, Vars: vars, client: client}
+}
+
+// DefaultBranch returns the default branch of the repo. It is only looked up
+// when a placeholder uses it.
+func (v *repoVars) DefaultBranch() (string, error) {
+	if v.defaultBranch != "" {
+		return v.defaultBranch, nil
+	}
+
+	repository, _, err := v.client.GetRepo(v.Owner, v.Repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to get default branch: %w", err)
+	}
+	v.defaultBranch = repository.DefaultBranch
+	return v.defaultBranch, nil
+}
+
+// render executes the placeholders in s. Strings without placeholders are
+// returned as is.
+func (v *repoVars) render(s string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	tmpl, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid placeholder in %q: %w", s, err)
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, v); err != nil {
+		return "", fmt.Errorf("failed to render %q: %w", s, err)
+	}
+	return out.String(), nil
+}
+
+// renderAll renders every string in the given fields in place
+func (v *repoVars) renderAll(fields ...*string) error {
+	for _, f := range fields {
+		rendered, err := v.render(*f)
+		if err != nil {
+			return err
+		}
+		*f = rendered
+	}
+	return nil
+}
+
+// renderer is implemented by handlers whose data may contain per-repo
+// placeholders. render returns the data with the placeholders filled in.
+type renderer interface {
+	render(data interface{}, vars *repoVars) (interface{}, error)
+}
+
+// renderStrings renders the placeholders in every string value of data and
+// returns a new value of the same type
+func renderStrings(data interface{}, vars *repoVars) (interface{}, error) {
+	var node yaml.Node
+	if err := node.Encode(data); err != nil {
+		return nil, err
+	}
+	if err := renderNode(&node, vars); err != nil {
+		return nil, err
+	}
+
+	return decodeAs(data, node.Decode)
+}
+
+func renderNode(node *yaml.Node, vars *repoVars) error {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		rendered, err := vars.render(node.Value)
+		if err != nil {
+			return err
+		}
+		node.Value = rendered
+		return nil
+	}
+
+	for i, child := range node.Content {
+		// keys of mappings are never rendered
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		if err := renderNode(child, vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
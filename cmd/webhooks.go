@@ -1,17 +1,36 @@
 package cmd
 
-// TODO: webhooks can support replace, append and merge (by target url but only the first webhook with this target url will be "merged")
+// Webhooks are identified by their type and target URL (config.url). IDs
+// cannot be used since they differ between the repository the settings were
+// pulled from and the target repositories.
+//
+// Several hooks can share an identity, e.g. two gitea hooks to the same URL
+// with different events. They are paired in order: the first hook in the file
+// with an identity updates the existing hook with that identity and the lowest
+// ID, the second one the next, and so on. A hook in the file that has no
+// partner after that may name the old URL of the hook it replaces as its key;
+// it then updates the existing hook with that URL. Hooks in the file without a
+// partner are created; existing hooks without a partner are kept by merge and
+// append and deleted by replace and sync.
+//
+// The Go Gitea SDK drops the branch filter and authorization header of hooks,
+// so they are listed with the REST API directly.
 
 import (
 	"fmt"
 	"os"
+	"slices"
+	"sort"
+	"strconv"
 
 	"code.gitea.io/sdk/gitea"
 	"gopkg.in/yaml.v3"
 )
 
 type Webhook struct {
-	ID                  int64             `yaml:"id"`
+	// ID is ignored; files pulled by older versions contain it
+	ID                  int64             `yaml:"id,omitempty"`
+	Key                 string            `yaml:"key,omitempty"`
 	Type                string            `yaml:"type"`
 	URL                 string            `yaml:"url,omitempty"`
 	BranchFilter        string            `yaml:"branch_filter,omitempty"`
@@ -21,11 +40,26 @@ type Webhook struct {
 	AuthorizationHeader string            `yaml:"authorization_header,omitempty"`
 }
 
+// giteaHook mirrors the Hook object of the Gitea API. The HTTP method is part
+// of its config as http_method.
+type giteaHook struct {
+	ID                  int64             `json:"id"`
+	Type                string            `json:"type"`
+	BranchFilter        string            `json:"branch_filter"`
+	Config              map[string]string `json:"config"`
+	Events              []string          `json:"events"`
+	Active              bool              `json:"active"`
+	AuthorizationHeader string            `json:"authorization_header"`
+}
+
 type WebhookConfig struct {
 	Hooks []Webhook `yaml:"hooks"`
 }
 
-type WebhooksHandler struct{}
+type WebhooksHandler struct {
+	Config *Config
+	DryRun bool
+}
 
 func (h *WebhooksHandler) Name() string {
 	return "webhooks"
@@ -36,124 +70,473 @@ func (h *WebhooksHandler) Path() string {
 }
 
 func (h *WebhooksHandler) Pull(client *gitea.Client, owner, repo string) (interface{}, error) {
-	webhooks, _, err := client.ListRepoHooks(owner, repo, gitea.ListHooksOptions{})
+	webhooks, err := listRepoHooks(h.Config, owner, repo)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list webhooks for %s/%s: %w", owner, repo, err)
 	}
 
+	// IDs are specific to the repository, so they are left out
 	transformed := make([]Webhook, len(webhooks))
 	for i, wh := range webhooks {
-		transformed[i] = Webhook{
-			ID:     wh.ID,
-			Type:   wh.Type,
-			URL:    wh.URL,
-			Config: wh.Config,
-			Events: wh.Events,
-			Active: wh.Active,
-		}
+		transformed[i] = withSecretPlaceholders(toWebhook(wh))
+		transformed[i].ID = 0
 	}
 	return WebhookConfig{Hooks: transformed}, nil
 }
 
-func (h *WebhooksHandler) Push(client *gitea.Client, owner, repo string, data interface{}) error {
+func (h *WebhooksHandler) Push(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error) {
+	changes, err := h.Plan(client, owner, repo, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyChanges(h.Name(), owner, repo, changes, h.DryRun)
+}
+
+func (h *WebhooksHandler) Plan(client *gitea.Client, owner, repo string, data interface{}) ([]Change, error) {
 	whConfig, ok := data.(WebhookConfig)
 	if !ok {
-		return fmt.Errorf("invalid data type for WebhooksHandler")
+		return nil, fmt.Errorf("invalid data type for WebhooksHandler")
 	}
 
-	cfg, err := LoadConfig(cfgFile)
+	strategy := h.Config.WebhooksUpdateStrategy
+	if err := h.validateUpdateStrategy(strategy); err != nil {
+		return nil, err
+	}
+
+	hooks, err := listRepoHooks(h.Config, owner, repo)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
 	}
 
-	strategy := cfg.WebhooksUpdateStrategy
-	if err := h.validateUpdateStrategy(strategy); err != nil {
-		return err
+	var changes []Change
+	if strategy == UpdateStrategyReplace {
+		for _, current := range hooks {
+			changes = append(changes, h.deleteChange(client, owner, repo, current))
+		}
+		for _, wh := range whConfig.Hooks {
+			changes = append(changes, h.createChange(client, owner, repo, wh))
+		}
+		return changes, nil
 	}
 
-	existingByID, countByURL, err := h.getExistingWebhooksMap(client, owner, repo)
+	live := make([]Webhook, len(hooks))
+	for i, current := range hooks {
+		live[i] = toWebhook(current)
+	}
+	resolved, err := resolveWebhooks(whConfig.Hooks)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	match := matchWebhooks(live, resolved)
 
-	if strategy == UpdateStrategyAppend {
-		for _, wh := range whConfig.Hooks {
-			// skip if there's already a webhook with the same target URL
-			if _, ok := countByURL[wh.URL]; ok {
-				continue
-			}
+	matched := make([]bool, len(hooks))
+	for i, wh := range whConfig.Hooks {
+		j := match[i]
+		if j < 0 {
+			changes = append(changes, h.createChange(client, owner, repo, wh))
+			continue
+		}
 
-			_, _, err := client.CreateRepoHook(owner, repo, toCreateHookOption(wh))
-			if err != nil {
-				return fmt.Errorf("failed to create webhook: %w", err)
-			}
+		matched[j] = true
+		if strategy == UpdateStrategyAppend {
+			continue
 		}
 
-		return nil
+		fields := webhookFields(live[j], wh, resolved[i])
+		if len(fields) == 0 {
+			continue
+		}
+		current := hooks[j]
+		changes = append(changes, Change{
+			Action: ChangeActionUpdate,
+			Item:   webhookLabel(wh),
+			Fields: fields,
+			apply: func() error {
+				opt, err := toEditHookOption(wh)
+				if err != nil {
+					return err
+				}
+				if _, err := client.EditRepoHook(owner, repo, current.ID, opt); err != nil {
+					return fmt.Errorf("failed to update webhook: %w", err)
+				}
+				return nil
+			},
+		})
 	}
 
-	if strategy == UpdateStrategyReplace {
-		for _, wh := range existingByID {
-			_, err := client.DeleteRepoHook(owner, repo, wh.ID)
-			if err != nil {
-				return fmt.Errorf("failed to delete webhook: %w", err)
+	if strategy == UpdateStrategySync {
+		for j, current := range hooks {
+			if !matched[j] {
+				changes = append(changes, h.deleteChange(client, owner, repo, current))
 			}
-			delete(existingByID, wh.ID)
 		}
+		changes = syncOrder(changes)
 	}
 
-	for _, wh := range whConfig.Hooks {
-		if count, ok := countByURL[wh.URL]; ok {
-			if count > 1 {
-				// skipping because there are multiple webhooks with the same target URL
-				continue
+	return changes, nil
+}
+
+func (h *WebhooksHandler) Diff(live, desired interface{}) ([]Change, error) {
+	liveWH, ok := live.(WebhookConfig)
+	desiredWH, ok2 := desired.(WebhookConfig)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("invalid data type for WebhooksHandler")
+	}
+
+	strategy := h.Config.WebhooksUpdateStrategy
+	if err := h.validateUpdateStrategy(strategy); err != nil {
+		return nil, err
+	}
+
+	resolved, err := resolveWebhooks(desiredWH.Hooks)
+	if err != nil {
+		return nil, err
+	}
+	match := matchWebhooks(liveWH.Hooks, resolved)
+	matched := make([]bool, len(liveWH.Hooks))
+
+	var changes []Change
+	for i, wh := range desiredWH.Hooks {
+		j := match[i]
+		if j < 0 {
+			changes = append(changes, Change{Action: ChangeActionCreate, Item: webhookLabel(wh)})
+			continue
+		}
+
+		matched[j] = true
+		fields := webhookFields(liveWH.Hooks[j], wh, resolved[i])
+		if len(fields) > 0 {
+			changes = append(changes, Change{Action: ChangeActionUpdate, Item: webhookLabel(wh), Fields: fields})
+		}
+	}
+	for j, wh := range liveWH.Hooks {
+		if !matched[j] {
+			changes = append(changes, Change{Action: ChangeActionDelete, Item: webhookLabel(wh)})
+		}
+	}
+	return strategyChanges(changes, strategy), nil
+}
+
+// matchWebhooks pairs the desired webhooks with live ones and returns the
+// index of the live partner of every desired webhook, or -1 if it has none.
+// Webhooks are matched by identity first; the key of a webhook is only used
+// for the webhooks left over, to find the hook it replaces.
+func matchWebhooks(live, desired []Webhook) []int {
+	used := make([]bool, len(live))
+	find := func(identity string) int {
+		for j, wh := range live {
+			if !used[j] && webhookIdentity(wh) == identity {
+				used[j] = true
+				return j
 			}
+		}
+		return -1
+	}
+
+	match := make([]int, len(desired))
+	for i, wh := range desired {
+		match[i] = find(webhookIdentity(wh))
+	}
+	for i, wh := range desired {
+		if match[i] < 0 && wh.Key != "" {
+			match[i] = find(fmt.Sprintf("%s (%s)", wh.Key, wh.Type))
+		}
+	}
+	return match
+}
 
-			_, err := client.EditRepoHook(owner, repo, wh.ID, toEditHookOption(wh))
+func (h *WebhooksHandler) createChange(client *gitea.Client, owner, repo string, wh Webhook) Change {
+	return Change{
+		Action: ChangeActionCreate,
+		Item:   webhookLabel(wh),
+		apply: func() error {
+			opt, err := toCreateHookOption(wh)
 			if err != nil {
-				return fmt.Errorf("failed to update webhook: %w", err)
+				return err
+			}
+			if _, _, err := client.CreateRepoHook(owner, repo, opt); err != nil {
+				return fmt.Errorf("failed to create webhook: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+func (h *WebhooksHandler) deleteChange(client *gitea.Client, owner, repo string, current *giteaHook) Change {
+	return Change{
+		Action: ChangeActionDelete,
+		Item:   webhookLabel(toWebhook(current)),
+		apply: func() error {
+			if _, err := client.DeleteRepoHook(owner, repo, current.ID); err != nil {
+				return fmt.Errorf("failed to delete webhook: %w", err)
 			}
+			return nil
+		},
+	}
+}
+
+func toWebhook(wh *giteaHook) Webhook {
+	return Webhook{
+		ID:                  wh.ID,
+		Type:                wh.Type,
+		BranchFilter:        wh.BranchFilter,
+		Config:              wh.Config,
+		Events:              wh.Events,
+		Active:              wh.Active,
+		AuthorizationHeader: wh.AuthorizationHeader,
+	}
+}
 
+// webhookTargetURL returns the URL a webhook sends its requests to. The
+// url field is only used when config.url is not set.
+func webhookTargetURL(wh Webhook) string {
+	if target := wh.Config["url"]; target != "" {
+		return target
+	}
+	return wh.URL
+}
+
+// webhookLabel returns a human readable name for a webhook in plan output.
+func webhookLabel(wh Webhook) string {
+	return fmt.Sprintf("%s (%s)", webhookTargetURL(wh), wh.Type)
+}
+
+// webhookIdentity returns what a webhook is matched with existing webhooks
+// by: its type and target URL
+func webhookIdentity(wh Webhook) string {
+	return webhookLabel(wh)
+}
+
+// comparableWebhook returns a webhook without the fields that are not part
+// of its settings in Gitea, so it can be compared with an existing one
+func comparableWebhook(wh Webhook) Webhook {
+	wh = withoutSecrets(withTargetURL(wh))
+	wh.ID = 0
+	wh.Key = ""
+	return wh
+}
+
+// webhookFields returns the settings of a live webhook that differ from the
+// desired ones. Gitea adds config keys of its own, e.g. the username of slack
+// hooks, so only the config keys set in the file are compared. Config values
+// that are secret references are compared by the value they resolve to, but
+// shown by their reference.
+func webhookFields(live, desired, resolved Webhook) []FieldChange {
+	live, desired, resolved = comparableWebhook(live), comparableWebhook(desired), comparableWebhook(resolved)
+
+	config := make(map[string]string, len(desired.Config))
+	for k, v := range desired.Config {
+		have, ok := live.Config[k]
+		if !ok {
 			continue
 		}
+		if isSecretRef(v) && have == resolved.Config[k] {
+			have = v
+		}
+		config[k] = have
+	}
+	live.Config = config
+	return diffFields(live, desired)
+}
+
+// withTargetURL moves the url field of a webhook into config.url, which is
+// where Gitea expects it
+func withTargetURL(wh Webhook) Webhook {
+	if wh.URL == "" {
+		return wh
+	}
+	config := make(map[string]string, len(wh.Config)+1)
+	for k, v := range wh.Config {
+		config[k] = v
+	}
+	if config["url"] == "" {
+		config["url"] = wh.URL
+	}
+	wh.Config = config
+	wh.URL = ""
+	return wh
+}
+
+// withSecretPlaceholders replaces the secrets of a pulled webhook with
+// references, so they never end up in the settings files
+func withSecretPlaceholders(wh Webhook) Webhook {
+	config := make(map[string]string, len(wh.Config))
+	for k, v := range wh.Config {
+		config[k] = v
+	}
+	for _, key := range secretConfigKeys {
+		if v, ok := config[key]; ok && v != "" && !isSecretRef(v) {
+			config[key] = secretPlaceholder(key)
+			logger.Warn("replaced webhook secret with a placeholder", "webhook", webhookLabel(wh), "placeholder", config[key])
+		}
+	}
+	if wh.Config != nil {
+		wh.Config = config
+	}
+
+	if wh.AuthorizationHeader != "" && !isSecretRef(wh.AuthorizationHeader) {
+		wh.AuthorizationHeader = secretPlaceholder("authorization_header")
+		logger.Warn("replaced webhook authorization header with a placeholder", "webhook", webhookLabel(wh), "placeholder", wh.AuthorizationHeader)
+	}
+	return wh
+}
+
+// withoutSecrets removes the values Gitea never returns from a webhook
+func withoutSecrets(wh Webhook) Webhook {
+	config := make(map[string]string, len(wh.Config))
+	for k, v := range wh.Config {
+		config[k] = v
+	}
+	for _, key := range secretConfigKeys {
+		delete(config, key)
+	}
+	if wh.Config != nil {
+		wh.Config = config
+	}
+	wh.AuthorizationHeader = ""
+	return wh
+}
 
-		_, _, err := client.CreateRepoHook(owner, repo, toCreateHookOption(wh))
+// resolveSecrets returns the config and authorization header of a webhook
+// with all secret references resolved
+func resolveSecrets(wh Webhook) (map[string]string, string, error) {
+	var config map[string]string
+	if wh.Config != nil {
+		config = make(map[string]string, len(wh.Config))
+	}
+	for k, v := range wh.Config {
+		resolved, err := resolveSecret(v)
 		if err != nil {
-			return fmt.Errorf("failed to create webhook: %w", err)
+			return nil, "", fmt.Errorf("failed to resolve config.%s of webhook %s: %w", k, webhookLabel(wh), err)
 		}
+		config[k] = resolved
+	}
 
+	header, err := resolveSecret(wh.AuthorizationHeader)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to resolve authorization_header of webhook %s: %w", webhookLabel(wh), err)
 	}
+	return config, header, nil
+}
 
-	return nil
+// resolveWebhooks returns the webhooks as Gitea returns them once they are
+// pushed: with the secret references in their URL, key and config resolved.
+// Secrets are left as they are, since Gitea never returns them.
+func resolveWebhooks(hooks []Webhook) ([]Webhook, error) {
+	resolved := make([]Webhook, len(hooks))
+	for i, wh := range hooks {
+		config := make(map[string]string, len(wh.Config))
+		for k, v := range wh.Config {
+			config[k] = v
+			if slices.Contains(secretConfigKeys, k) {
+				continue
+			}
+			value, err := resolveSecret(v)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve config.%s of webhook %s: %w", k, webhookLabel(wh), err)
+			}
+			config[k] = value
+		}
+		if wh.Config != nil {
+			wh.Config = config
+		}
+
+		var err error
+		if wh.URL, err = resolveSecret(wh.URL); err != nil {
+			return nil, fmt.Errorf("failed to resolve url of webhook %s: %w", webhookLabel(wh), err)
+		}
+		if wh.Key, err = resolveSecret(wh.Key); err != nil {
+			return nil, fmt.Errorf("failed to resolve key of webhook %s: %w", webhookLabel(wh), err)
+		}
+		resolved[i] = wh
+	}
+	return resolved, nil
 }
 
-func toEditHookOption(wh Webhook) gitea.EditHookOption {
+func toEditHookOption(wh Webhook) (gitea.EditHookOption, error) {
+	config, header, err := resolveSecrets(withTargetURL(wh))
+	if err != nil {
+		return gitea.EditHookOption{}, err
+	}
+
 	return gitea.EditHookOption{
-		Config:              wh.Config,
+		Config:              config,
 		Events:              wh.Events,
 		BranchFilter:        wh.BranchFilter,
 		Active:              &wh.Active,
-		AuthorizationHeader: wh.AuthorizationHeader, // TODO: how to deal with secrets?
-	}
+		AuthorizationHeader: header,
+	}, nil
 }
 
-func toCreateHookOption(wh Webhook) gitea.CreateHookOption {
+func toCreateHookOption(wh Webhook) (gitea.CreateHookOption, error) {
+	config, header, err := resolveSecrets(withTargetURL(wh))
+	if err != nil {
+		return gitea.CreateHookOption{}, err
+	}
+
 	return gitea.CreateHookOption{
-		Type: gitea.HookType(wh.Type),
-		// URL:          wh.URL, // TODO: not supported yet
-		// Method:       wh.Method, // TODO: for some reason not returned by the Gitea API
-		Config:              wh.Config,
+		Type:                gitea.HookType(wh.Type),
+		Config:              config,
 		Events:              wh.Events,
 		BranchFilter:        wh.BranchFilter,
 		Active:              wh.Active,
-		AuthorizationHeader: wh.AuthorizationHeader, // TODO: how to deal with secrets?
-	}
+		AuthorizationHeader: header,
+	}, nil
 }
 
 func (h *WebhooksHandler) Enabled() bool {
 	return true
 }
 
+func (h *WebhooksHandler) render(data interface{}, vars *repoVars) (interface{}, error) {
+	whConfig, ok := data.(WebhookConfig)
+	if !ok {
+		return nil, fmt.Errorf("invalid data type for WebhooksHandler")
+	}
+
+	hooks := make([]Webhook, len(whConfig.Hooks))
+	for i, wh := range whConfig.Hooks {
+		if err := vars.renderAll(&wh.URL, &wh.Key); err != nil {
+			return nil, err
+		}
+
+		config := make(map[string]string, len(wh.Config))
+		for k, v := range wh.Config {
+			rendered, err := vars.render(v)
+			if err != nil {
+				return nil, err
+			}
+			config[k] = rendered
+		}
+		if wh.Config != nil {
+			wh.Config = config
+		}
+		hooks[i] = wh
+	}
+	return WebhookConfig{Hooks: hooks}, nil
+}
+
+func (h *WebhooksHandler) newData() interface{} {
+	return &WebhookConfig{}
+}
+
+func (h *WebhooksHandler) validateData(data interface{}) []fieldError {
+	var errs []fieldError
+	for i, wh := range data.(*WebhookConfig).Hooks {
+		index := strconv.Itoa(i)
+		if wh.Type == "" {
+			errs = append(errs, fieldErr("type is required", "hooks", index))
+		}
+		errs = append(errs, checkEnum(wh.Type, supportedWebhookTypes, "hooks", index, "type")...)
+		errs = append(errs, checkEnum(wh.Config["content_type"], supportedWebhookContents, "hooks", index, "config", "content_type")...)
+		if wh.Config["url"] == "" && wh.URL == "" {
+			errs = append(errs, fieldErr("config.url is required", "hooks", index))
+		}
+	}
+	return errs
+}
+
 func (h *WebhooksHandler) Load(path string) (interface{}, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
@@ -166,21 +549,19 @@ func (h *WebhooksHandler) Load(path string) (interface{}, error) {
 	return config, nil
 }
 
-func (h *WebhooksHandler) getExistingWebhooksMap(client *gitea.Client, owner, repo string) (map[int64]*gitea.Hook, map[string]int, error) {
-	webhooks, _, err := client.ListRepoHooks(owner, repo, gitea.ListHooksOptions{})
+// listRepoHooks returns the webhooks of a repository, sorted by ID
+func listRepoHooks(cfg *Config, owner, repo string) ([]*giteaHook, error) {
+	hooks, err := listAll(func(opts gitea.ListOptions) ([]*giteaHook, *gitea.Response, error) {
+		var page []*giteaHook
+		path := fmt.Sprintf("/repos/%s/%s/hooks?page=%d&limit=%d", owner, repo, opts.Page, opts.PageSize)
+		resp, err := giteaAPIList(cfg, path, &page)
+		return page, resp, err
+	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to list webhooks: %w", err)
-	}
-
-	byID := make(map[int64]*gitea.Hook, len(webhooks))
-	countByURL := make(map[string]int, len(webhooks))
-
-	for _, wh := range webhooks {
-		byID[wh.ID] = wh
-		countByURL[wh.URL]++
+		return nil, err
 	}
-
-	return byID, countByURL, nil
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+	return hooks, nil
 }
 
 func (h *WebhooksHandler) validateUpdateStrategy(strategy UpdateStrategy) error {
@@ -188,10 +569,11 @@ func (h *WebhooksHandler) validateUpdateStrategy(strategy UpdateStrategy) error
 		UpdateStrategyReplace: true,
 		UpdateStrategyMerge:   true,
 		UpdateStrategyAppend:  true,
+		UpdateStrategySync:    true,
 	}
 
 	if _, ok := supported[strategy]; !ok {
-		return fmt.Errorf("invalid webhooks_update_strategy: %s (must be 'replace', 'merge', or 'append')", strategy)
+		return fmt.Errorf("invalid webhooks_update_strategy: %s (must be 'replace', 'merge', 'append' or 'sync')", strategy)
 	}
 
 	return nil
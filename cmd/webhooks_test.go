@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"code.gitea.io/sdk/gitea"
+)
+
+// fakeHooks serves the webhook endpoints of one repository like Gitea does,
+// including leaving out secrets
+type fakeHooks struct {
+	mu     sync.Mutex
+	hooks  map[int64]*giteaHook
+	nextID int64
+}
+
+func newFakeHooks(hooks ...giteaHook) *fakeHooks {
+	f := &fakeHooks{hooks: make(map[int64]*giteaHook)}
+	for _, h := range hooks {
+		f.add(h)
+	}
+	return f
+}
+
+func (f *fakeHooks) add(h giteaHook) *giteaHook {
+	f.nextID++
+	h.ID = f.nextID
+	f.hooks[h.ID] = &h
+	return &h
+}
+
+func (f *fakeHooks) list() []giteaHook {
+	var hooks []giteaHook
+	for _, h := range f.hooks {
+		hook := *h
+		hook.Config = make(map[string]string)
+		for k, v := range h.Config {
+			if k != "secret" {
+				hook.Config[k] = v
+			}
+		}
+		hooks = append(hooks, hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+	return hooks
+}
+
+func (f *fakeHooks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+	switch {
+	case path == "/version":
+		writeJSON(w, http.StatusOK, map[string]string{"version": "1.22.0"})
+
+	case path == "/repos/owner/repo/hooks" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, f.list())
+
+	case path == "/repos/owner/repo/hooks" && r.Method == http.MethodPost:
+		var opt gitea.CreateHookOption
+		if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h := f.add(giteaHook{
+			Type:                string(opt.Type),
+			BranchFilter:        opt.BranchFilter,
+			Config:              opt.Config,
+			Events:              opt.Events,
+			Active:              opt.Active,
+			AuthorizationHeader: opt.AuthorizationHeader,
+		})
+		writeJSON(w, http.StatusCreated, h)
+
+	case strings.HasPrefix(path, "/repos/owner/repo/hooks/"):
+		var id int64
+		fmt.Sscanf(strings.TrimPrefix(path, "/repos/owner/repo/hooks/"), "%d", &id)
+		h, ok := f.hooks[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPatch:
+			var opt gitea.EditHookOption
+			if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			h.Config = opt.Config
+			h.Events = opt.Events
+			h.BranchFilter = opt.BranchFilter
+			h.AuthorizationHeader = opt.AuthorizationHeader
+			if opt.Active != nil {
+				h.Active = *opt.Active
+			}
+			writeJSON(w, http.StatusOK, h)
+		case http.MethodDelete:
+			delete(f.hooks, id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestWebhooksPushTwice(t *testing.T) {
+	t.Setenv("TEST_WEBHOOK_SECRET", "s3cret")
+	t.Setenv("TEST_SLACK_URL", "https://hooks.slack.com/services/T0/B0/X")
+
+	desired := WebhookConfig{Hooks: []Webhook{
+		{
+			Key:    "https://old.example.com/hook",
+			Type:   "gitea",
+			Config: map[string]string{"url": "https://new.example.com/hook", "content_type": "json", "secret": "env:TEST_WEBHOOK_SECRET"},
+			Events: []string{"push"},
+			Active: true,
+		},
+		{
+			Type:   "gitea",
+			Config: map[string]string{"url": "https://ci.example.com/hook", "content_type": "json"},
+			Events: []string{"push"},
+			Active: true,
+		},
+		{
+			Type:   "gitea",
+			Config: map[string]string{"url": "https://ci.example.com/hook", "content_type": "json"},
+			Events: []string{"pull_request"},
+			Active: true,
+		},
+		{
+			Type:   "slack",
+			Config: map[string]string{"url": "env:TEST_SLACK_URL", "channel": "#ci"},
+			Events: []string{"push"},
+			Active: true,
+		},
+	}}
+
+	tests := []struct {
+		strategy     UpdateStrategy
+		firstChanges []ChangeAction
+		hooksAfter   int
+	}{
+		{UpdateStrategyMerge, []ChangeAction{ChangeActionUpdate, ChangeActionCreate, ChangeActionCreate}, 5},
+		{UpdateStrategyAppend, []ChangeAction{ChangeActionCreate, ChangeActionCreate}, 5},
+		{UpdateStrategySync, []ChangeAction{ChangeActionUpdate, ChangeActionCreate, ChangeActionCreate, ChangeActionDelete}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			fake := newFakeHooks(
+				giteaHook{Type: "gitea", Config: map[string]string{"url": "https://old.example.com/hook", "content_type": "form"}, Events: []string{"push"}, Active: true},
+				giteaHook{Type: "slack", Config: map[string]string{"url": "https://hooks.slack.com/services/T0/B0/X", "channel": "#ci", "username": "gitea"}, Events: []string{"push"}, Active: true},
+				giteaHook{Type: "slack", Config: map[string]string{"url": "https://hooks.slack.com/unrelated"}, Events: []string{"push"}, Active: true},
+			)
+			server := httptest.NewServer(fake)
+			defer server.Close()
+
+			cfg := &Config{GiteaURL: server.URL, GiteaToken: "token", WebhooksUpdateStrategy: tt.strategy}
+			client, err := gitea.NewClient(server.URL, gitea.SetToken("token"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := &WebhooksHandler{Config: cfg}
+
+			changes, err := h.Push(client, "owner", "repo", desired)
+			if err != nil {
+				t.Fatalf("first push: %v", err)
+			}
+			if got := changeActions(changes); !equalActions(got, tt.firstChanges) {
+				t.Errorf("first push changes = %v, want %v", got, tt.firstChanges)
+			}
+			if got := len(fake.hooks); got != tt.hooksAfter {
+				t.Errorf("hooks after first push = %d, want %d", got, tt.hooksAfter)
+			}
+
+			changes, err = h.Push(client, "owner", "repo", desired)
+			if err != nil {
+				t.Fatalf("second push: %v", err)
+			}
+			if len(changes) != 0 {
+				t.Errorf("second push changes = %v, want none", changeActions(changes))
+			}
+			if got := len(fake.hooks); got != tt.hooksAfter {
+				t.Errorf("hooks after second push = %d, want %d", got, tt.hooksAfter)
+			}
+		})
+	}
+}
+
+func changeActions(changes []Change) []ChangeAction {
+	actions := make([]ChangeAction, len(changes))
+	for i, c := range changes {
+		actions[i] = c.Action
+	}
+	return actions
+}
+
+func equalActions(a, b []ChangeAction) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWebhookFields(t *testing.T) {
+	t.Setenv("TEST_SLACK_URL", "https://hooks.slack.com/a")
+
+	slack := func(config map[string]string) Webhook {
+		return Webhook{Type: "slack", Config: config, Events: []string{"push"}, Active: true}
+	}
+
+	tests := []struct {
+		name    string
+		live    Webhook
+		desired Webhook
+		want    []string
+	}{
+		{
+			name:    "extra live config keys are ignored",
+			live:    slack(map[string]string{"url": "https://hooks.slack.com/a", "channel": "#ci", "username": "gitea", "color": "good"}),
+			desired: slack(map[string]string{"url": "https://hooks.slack.com/a", "channel": "#ci"}),
+		},
+		{
+			name:    "changed config key",
+			live:    slack(map[string]string{"url": "https://hooks.slack.com/a", "channel": "#ci", "username": "gitea"}),
+			desired: slack(map[string]string{"url": "https://hooks.slack.com/a", "channel": "#builds"}),
+			want:    []string{"config"},
+		},
+		{
+			name:    "missing config key",
+			live:    slack(map[string]string{"url": "https://hooks.slack.com/a"}),
+			desired: slack(map[string]string{"url": "https://hooks.slack.com/a", "channel": "#ci"}),
+			want:    []string{"config"},
+		},
+		{
+			name:    "secret is never compared",
+			live:    slack(map[string]string{"url": "https://hooks.slack.com/a"}),
+			desired: slack(map[string]string{"url": "https://hooks.slack.com/a", "secret": "env:SLACK_SECRET"}),
+		},
+		{
+			name:    "url field is compared as config.url",
+			live:    slack(map[string]string{"url": "https://hooks.slack.com/a"}),
+			desired: Webhook{Type: "slack", URL: "https://hooks.slack.com/a", Events: []string{"push"}, Active: true},
+		},
+		{
+			name:    "reference resolving to the live value",
+			live:    slack(map[string]string{"url": "https://hooks.slack.com/a"}),
+			desired: slack(map[string]string{"url": "env:TEST_SLACK_URL"}),
+		},
+		{
+			name:    "reference resolving to another value",
+			live:    slack(map[string]string{"url": "https://hooks.slack.com/b"}),
+			desired: slack(map[string]string{"url": "env:TEST_SLACK_URL"}),
+			want:    []string{"config"},
+		},
+		{
+			name:    "changed events and active",
+			live:    slack(map[string]string{"url": "https://hooks.slack.com/a"}),
+			desired: Webhook{Type: "slack", Config: map[string]string{"url": "https://hooks.slack.com/a"}, Events: []string{"push", "create"}},
+			want:    []string{"events", "active"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			resolved, err := resolveWebhooks([]Webhook{tt.desired})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range webhookFields(tt.live, tt.desired, resolved[0]) {
+				got = append(got, f.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("changed fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
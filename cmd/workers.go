@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// repoResult is the outcome of processing a single repository
+type repoResult struct {
+	Repo     string
+	Err      error
+	Skipped  bool
+	Handlers []handlerResult
+}
+
+// handlerResult is the outcome of a single handler for a repository
+type handlerResult struct {
+	Handler  string
+	Changes  []Change
+	Err      error
+	Skipped  bool
+	Duration time.Duration
+}
+
+// forEachRepo calls fn for every repo using a pool of at most concurrency
+// workers. If stopOnError is set, no further repos are started once a call
+// fails; those are reported as skipped. Results are returned in the order of
+// repos, regardless of the order in which the workers finished.
+func forEachRepo(
+	repos []string,
+	concurrency int,
+	stopOnError bool,
+	fn func(fullName string) ([]handlerResult, error),
+) []repoResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(repos) {
+		concurrency = len(repos)
+	}
+
+	results := make([]repoResult, len(repos))
+	for i, repo := range repos {
+		results[i] = repoResult{Repo: repo, Skipped: true}
+	}
+
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// a repo handed out while another one was failing is skipped too
+				if stopOnError && failed.Load() {
+					continue
+				}
+				handlers, err := fn(repos[i])
+				results[i] = repoResult{Repo: repos[i], Err: err, Handlers: handlers}
+				if err != nil {
+					failed.Store(true)
+				}
+			}
+		}()
+	}
+
+	for i := range repos {
+		if stopOnError && failed.Load() {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForEachRepo(t *testing.T) {
+	repos := []string{"org/a", "org/b", "org/c", "org/d", "org/e", "org/f"}
+
+	tests := []struct {
+		name        string
+		concurrency int
+	}{
+		{"sequential", 1},
+		{"zero concurrency runs sequentially", 0},
+		{"parallel", 3},
+		{"more workers than repos", 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var running, peak atomic.Int32
+			results := forEachRepo(repos, tt.concurrency, false, func(fullName string) ([]handlerResult, error) {
+				n := running.Add(1)
+				defer running.Add(-1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				return []handlerResult{{Handler: fullName}}, nil
+			})
+
+			maxWorkers := max(tt.concurrency, 1)
+			if got := int(peak.Load()); got > maxWorkers {
+				t.Errorf("%d repos ran at once, want at most %d", got, maxWorkers)
+			}
+			if len(results) != len(repos) {
+				t.Fatalf("got %d results, want %d", len(results), len(repos))
+			}
+			for i, r := range results {
+				if r.Repo != repos[i] || r.Skipped || r.Err != nil || len(r.Handlers) != 1 || r.Handlers[0].Handler != repos[i] {
+					t.Errorf("result %d = %+v, want the result of %s", i, r, repos[i])
+				}
+			}
+		})
+	}
+}
+
+func TestForEachRepoErrors(t *testing.T) {
+	repos := []string{"org/a", "org/b", "org/c", "org/d"}
+	fn := func(fullName string) ([]handlerResult, error) {
+		if fullName == "org/b" {
+			return nil, errors.New("boom")
+		}
+		return nil, nil
+	}
+
+	tests := []struct {
+		stopOnError bool
+		want        []string
+	}{
+		{false, []string{"ok", "failed", "ok", "ok"}},
+		{true, []string{"ok", "failed", "skipped", "skipped"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("stopOnError=%v", tt.stopOnError), func(t *testing.T) {
+			results := forEachRepo(repos, 1, tt.stopOnError, fn)
+			for i, r := range results {
+				got := "ok"
+				switch {
+				case r.Err != nil:
+					got = "failed"
+				case r.Skipped:
+					got = "skipped"
+				}
+				if r.Repo != repos[i] || got != tt.want[i] {
+					t.Errorf("result %d = %s %s, want %s %s", i, r.Repo, got, repos[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestForEachRepoWithoutRepos(t *testing.T) {
+	results := forEachRepo(nil, 4, true, func(string) ([]handlerResult, error) {
+		t.Error("fn called without repos")
+		return nil, nil
+	})
+	if len(results) != 0 {
+		t.Errorf("got %d results, want none", len(results))
+	}
+}